@@ -0,0 +1,120 @@
+package provider
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+func dataSourceDockerContainerFile() *schema.Resource {
+	return &schema.Resource{
+		Read: dataSourceDockerContainerFileRead,
+
+		Schema: map[string]*schema.Schema{
+			"host": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+
+			"machine_name": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+
+			"cert_path": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+
+			"ca_material": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+
+			"cert_material": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+
+			"key_material": {
+				Type:      schema.TypeString,
+				Optional:  true,
+				Sensitive: true,
+			},
+
+			"container_id": {
+				Type:     schema.TypeString,
+				Required: true,
+			},
+
+			"file": {
+				Type:         schema.TypeString,
+				Required:     true,
+				ValidateFunc: validateDockerContainerPath,
+			},
+
+			"content": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+
+			"content_base64": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+
+			"mode": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+
+			"uid": {
+				Type:     schema.TypeInt,
+				Computed: true,
+			},
+
+			"gid": {
+				Type:     schema.TypeInt,
+				Computed: true,
+			},
+
+			"size": {
+				Type:     schema.TypeInt,
+				Computed: true,
+			},
+		},
+	}
+}
+
+func dataSourceDockerContainerFileRead(d *schema.ResourceData, meta interface{}) error {
+	providerConfig := meta.(*ProviderConfig)
+	resolvedConfig, _, err := providerConfig.GetResolvedConfig(d)
+	if err != nil {
+		return err
+	}
+	client, err := resolvedConfig.NewClient()
+	if err != nil {
+		return err
+	}
+
+	containerID := d.Get("container_id").(string)
+	file := d.Get("file").(string)
+
+	hdr, content, err := fetchDockerContainerFile(containerID, file, client)
+	if err != nil {
+		return err
+	}
+	if hdr == nil {
+		return fmt.Errorf("Unable to find %s in container %s", file, containerID)
+	}
+
+	d.SetId(containerID + ":" + file)
+	d.Set("content", string(content))
+	d.Set("content_base64", base64.StdEncoding.EncodeToString(content))
+	d.Set("mode", fmt.Sprintf("%04o", hdr.Mode&07777))
+	d.Set("uid", hdr.Uid)
+	d.Set("gid", hdr.Gid)
+	d.Set("size", hdr.Size)
+	return nil
+}
@@ -0,0 +1,140 @@
+package provider
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/hashicorp/terraform/helper/schema"
+
+	dc "github.com/fsouza/go-dockerclient"
+)
+
+func dataSourceDockerContainerLogs() *schema.Resource {
+	return &schema.Resource{
+		Read: dataSourceDockerContainerLogsRead,
+
+		Schema: map[string]*schema.Schema{
+			"host": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+
+			"machine_name": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+
+			"cert_path": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+
+			"ca_material": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+
+			"cert_material": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+
+			"key_material": {
+				Type:      schema.TypeString,
+				Optional:  true,
+				Sensitive: true,
+			},
+
+			"container_id": {
+				Type:     schema.TypeString,
+				Required: true,
+			},
+
+			"stdout": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  true,
+			},
+
+			"stderr": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  true,
+			},
+
+			// Number of lines to return from the end of the logs, or "all".
+			"tail": {
+				Type:     schema.TypeString,
+				Optional: true,
+				Default:  "all",
+			},
+
+			// Only return logs since this UNIX timestamp.
+			"since": {
+				Type:     schema.TypeInt,
+				Optional: true,
+			},
+
+			"timestamps": {
+				Type:     schema.TypeBool,
+				Optional: true,
+			},
+
+			"output": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+
+			"stdout_output": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+
+			"stderr_output": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+		},
+	}
+}
+
+func dataSourceDockerContainerLogsRead(d *schema.ResourceData, meta interface{}) error {
+	providerConfig := meta.(*ProviderConfig)
+	resolvedConfig, _, err := providerConfig.GetResolvedConfig(d)
+	if err != nil {
+		return err
+	}
+	client, err := resolvedConfig.NewClient()
+	if err != nil {
+		return err
+	}
+
+	containerID := d.Get("container_id").(string)
+	container, err := client.InspectContainer(containerID)
+	if err != nil {
+		return fmt.Errorf("Error inspecting container %s: %s", containerID, err)
+	}
+
+	output := new(bytes.Buffer)
+	stdout := new(bytes.Buffer)
+	stderr := new(bytes.Buffer)
+	err = fetchDockerContainerLogs(container, dc.LogsOptions{
+		OutputStream: io.MultiWriter(output, stdout),
+		ErrorStream:  io.MultiWriter(output, stderr),
+		Stdout:       d.Get("stdout").(bool),
+		Stderr:       d.Get("stderr").(bool),
+		Tail:         d.Get("tail").(string),
+		Since:        int64(d.Get("since").(int)),
+		Timestamps:   d.Get("timestamps").(bool),
+	}, client)
+	if err != nil {
+		return err
+	}
+
+	d.SetId(container.ID)
+	d.Set("output", output.String())
+	d.Set("stdout_output", stdout.String())
+	d.Set("stderr_output", stderr.String())
+	return nil
+}
@@ -0,0 +1,311 @@
+package provider
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/hashicorp/terraform/helper/schema"
+
+	dc "github.com/fsouza/go-dockerclient"
+)
+
+// dataSourceDockerImage looks up an image already present on the host,
+// either by name, ID prefix or digest, or through ListImages filters.
+func dataSourceDockerImage() *schema.Resource {
+	return &schema.Resource{
+		Read: dataSourceDockerImageRead,
+
+		Schema: map[string]*schema.Schema{
+			"host": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+
+			"machine_name": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+
+			"cert_path": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+
+			"ca_material": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+
+			"cert_material": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+
+			"key_material": {
+				Type:      schema.TypeString,
+				Optional:  true,
+				Sensitive: true,
+			},
+
+			// repository:tag, an ID or ID prefix, or repository@digest.
+			"name": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				ConflictsWith: []string{"label_filters", "dangling", "reference"},
+			},
+
+			// Labels the image must carry, a "" value only requires the
+			// label to be present.
+			"label_filters": {
+				Type:     schema.TypeMap,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+				Optional: true,
+			},
+
+			"dangling": {
+				Type:     schema.TypeBool,
+				Optional: true,
+			},
+
+			// Reference pattern, e.g. "app:1.*".
+			"reference": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+
+			// Pick the newest image when the filters match several.
+			"most_recent": {
+				Type:     schema.TypeBool,
+				Optional: true,
+			},
+
+			"id": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+
+			"created_at": {
+				Type:     schema.TypeInt,
+				Computed: true,
+			},
+
+			"docker_version": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+
+			"comment": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+
+			"author": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+
+			"os": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+
+			"architecture": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+
+			"size": {
+				Type:     schema.TypeInt,
+				Computed: true,
+			},
+
+			"virtual_size": {
+				Type:     schema.TypeInt,
+				Computed: true,
+			},
+
+			"parent": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+
+			"digests": {
+				Type:     schema.TypeList,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+				Computed: true,
+			},
+
+			"all_tags": {
+				Type:     schema.TypeList,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+				Computed: true,
+			},
+
+			"labels": {
+				Type:     schema.TypeMap,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+				Computed: true,
+			},
+
+			"env": {
+				Type:     schema.TypeList,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+				Computed: true,
+			},
+
+			"entrypoint": {
+				Type:     schema.TypeList,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+				Computed: true,
+			},
+
+			"cmd": {
+				Type:     schema.TypeList,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+				Computed: true,
+			},
+
+			// Exposed ports as "port/protocol".
+			"exposed_ports": {
+				Type:     schema.TypeList,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+				Computed: true,
+			},
+
+			// Layers, newest first.
+			"history": {
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"id": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"created_at": {
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						"created_by": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"comment": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"size": {
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						"tags": {
+							Type:     schema.TypeList,
+							Elem:     &schema.Schema{Type: schema.TypeString},
+							Computed: true,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func dataSourceDockerImageRead(d *schema.ResourceData, meta interface{}) error {
+	providerConfig := meta.(*ProviderConfig)
+	resolvedConfig, _, err := providerConfig.GetResolvedConfig(d)
+	if err != nil {
+		return err
+	}
+	client, err := resolvedConfig.NewClient()
+	if err != nil {
+		return err
+	}
+
+	name := d.Get("name").(string)
+	if name == "" {
+		if name, err = findDockerImage(d, client); err != nil {
+			return err
+		}
+	}
+
+	image, err := client.InspectImage(name)
+	if err == dc.ErrNoSuchImage {
+		return fmt.Errorf("Image %s not found", name)
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to inspect image %s: %s", name, err)
+	}
+
+	history, err := client.ImageHistory(image.ID)
+	if err != nil {
+		return fmt.Errorf("Unable to get the history of image %s: %s", name, err)
+	}
+
+	d.SetId(image.ID)
+	d.Set("id", image.ID)
+	readDockerImageAttributes(d, image)
+
+	if image.Config != nil {
+		d.Set("env", image.Config.Env)
+		d.Set("entrypoint", image.Config.Entrypoint)
+		d.Set("cmd", image.Config.Cmd)
+		var ports []string
+		for port := range image.Config.ExposedPorts {
+			ports = append(ports, string(port))
+		}
+		sort.Strings(ports)
+		d.Set("exposed_ports", ports)
+	}
+
+	var layers []map[string]interface{}
+	for _, layer := range history {
+		layers = append(layers, map[string]interface{}{
+			"id":         layer.ID,
+			"created_at": int(layer.Created),
+			"created_by": layer.CreatedBy,
+			"comment":    layer.Comment,
+			"size":       int(layer.Size),
+			"tags":       layer.Tags,
+		})
+	}
+	d.Set("history", layers)
+
+	return nil
+}
+
+// findDockerImage returns the ID of the image matching the filters.
+func findDockerImage(d *schema.ResourceData, client *dc.Client) (string, error) {
+	filters := make(map[string][]string)
+	for k, v := range d.Get("label_filters").(map[string]interface{}) {
+		if v.(string) == "" {
+			filters["label"] = append(filters["label"], k)
+		} else {
+			filters["label"] = append(filters["label"], k+"="+v.(string))
+		}
+	}
+	if d.Get("dangling").(bool) {
+		filters["dangling"] = []string{"true"}
+	}
+	if v := d.Get("reference").(string); v != "" {
+		filters["reference"] = []string{v}
+	}
+	if len(filters) == 0 {
+		return "", fmt.Errorf("One of name, label_filters, dangling or reference must be set")
+	}
+
+	images, err := client.ListImages(dc.ListImagesOptions{Filters: filters})
+	if err != nil {
+		return "", fmt.Errorf("Unable to list images: %s", err)
+	}
+	switch {
+	case len(images) == 0:
+		return "", fmt.Errorf("No image matches %v", filters)
+	case len(images) > 1 && !d.Get("most_recent").(bool):
+		return "", fmt.Errorf("%d images match %v, set most_recent or narrow the filters", len(images), filters)
+	}
+
+	sort.Slice(images, func(i, j int) bool {
+		return images[i].Created > images[j].Created
+	})
+	return images[0].ID, nil
+}
@@ -0,0 +1,215 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+// dataSourceDockerRegistryImage looks up an image in its registry through
+// the v2 API, without the daemon pulling it.
+func dataSourceDockerRegistryImage() *schema.Resource {
+	return &schema.Resource{
+		Read: dataSourceDockerRegistryImageRead,
+
+		Schema: map[string]*schema.Schema{
+			"registry": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+
+			"name": {
+				Type:     schema.TypeString,
+				Required: true,
+			},
+
+			"tag": {
+				Type:     schema.TypeString,
+				Default:  "latest",
+				Optional: true,
+			},
+
+			// Platform whose config the labels are read from when the tag
+			// is a manifest list, as "os/architecture[/variant]". Defaults
+			// to the first entry of the list.
+			"platform": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+
+			"auth": {
+				Type: schema.TypeList,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"registry": {
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"username": {
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"password": {
+							Type:      schema.TypeString,
+							Required:  true,
+							Sensitive: true,
+						},
+					},
+				},
+				Optional: true,
+			},
+
+			"digest": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+
+			// registry/name@digest, to pin images and containers.
+			"pinned_name": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+
+			"media_type": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+
+			"platforms": {
+				Type:     schema.TypeList,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+				Computed: true,
+			},
+
+			"labels": {
+				Type:     schema.TypeMap,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+				Computed: true,
+			},
+		},
+	}
+}
+
+type registryManifestList struct {
+	Manifests []struct {
+		Digest   string `json:"digest"`
+		Platform struct {
+			Architecture string `json:"architecture"`
+			OS           string `json:"os"`
+			Variant      string `json:"variant"`
+		} `json:"platform"`
+	} `json:"manifests"`
+}
+
+type registryImageManifest struct {
+	Config struct {
+		Digest string `json:"digest"`
+	} `json:"config"`
+}
+
+type registryImageConfig struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant"`
+	Config       struct {
+		Labels map[string]string `json:"Labels"`
+	} `json:"config"`
+}
+
+func dataSourceDockerRegistryImageRead(d *schema.ResourceData, meta interface{}) error {
+	providerConfig := meta.(*ProviderConfig)
+	registry := d.Get("registry").(string)
+	authConfig, err := getAuthConfig(d, providerConfig, registry)
+	if err != nil {
+		return err
+	}
+
+	repository := registryRepository(registry, d.Get("name").(string))
+	tag := d.Get("tag").(string)
+	client := newRegistryClient(registry, authConfig[registry])
+
+	manifest, err := client.fetchManifest(repository, tag, false)
+	if err != nil {
+		return err
+	}
+
+	pinnedName := d.Get("name").(string) + "@" + manifest.Digest
+	if registry != "" {
+		pinnedName = registry + "/" + pinnedName
+	}
+	d.SetId(pinnedName)
+	d.Set("digest", manifest.Digest)
+	d.Set("pinned_name", pinnedName)
+	d.Set("media_type", manifest.MediaType)
+
+	imageManifest := manifest
+	var platforms []string
+	if isRegistryManifestList(manifest.MediaType) {
+		var list registryManifestList
+		if err := json.Unmarshal(manifest.Body, &list); err != nil {
+			return fmt.Errorf("Invalid manifest list for %s:%s: %s", repository, tag, err)
+		}
+		wanted := d.Get("platform").(string)
+		selected := ""
+		for _, entry := range list.Manifests {
+			platform := formatRegistryPlatform(entry.Platform.OS, entry.Platform.Architecture, entry.Platform.Variant)
+			platforms = append(platforms, platform)
+			if selected == "" && (wanted == "" || platformMatches(wanted, platform)) {
+				selected = entry.Digest
+			}
+		}
+		if selected == "" {
+			return fmt.Errorf("%s:%s has no image for platform %s (found %s)", repository, tag, wanted, strings.Join(platforms, ", "))
+		}
+		if imageManifest, err = client.fetchManifest(repository, selected, false); err != nil {
+			return err
+		}
+	}
+
+	var image registryImageManifest
+	if err := json.Unmarshal(imageManifest.Body, &image); err != nil {
+		return fmt.Errorf("Invalid manifest for %s:%s: %s", repository, tag, err)
+	}
+	if image.Config.Digest == "" {
+		return fmt.Errorf("Unsupported manifest %s for %s:%s", imageManifest.MediaType, repository, tag)
+	}
+	blob, err := client.fetchBlob(repository, image.Config.Digest)
+	if err != nil {
+		return err
+	}
+	var config registryImageConfig
+	if err := json.Unmarshal(blob, &config); err != nil {
+		return fmt.Errorf("Invalid image config for %s:%s: %s", repository, tag, err)
+	}
+
+	if platforms == nil {
+		platforms = []string{formatRegistryPlatform(config.OS, config.Architecture, config.Variant)}
+	}
+	d.Set("platforms", platforms)
+	d.Set("labels", config.Config.Labels)
+	return nil
+}
+
+func isRegistryManifestList(mediaType string) bool {
+	return strings.HasPrefix(mediaType, "application/vnd.docker.distribution.manifest.list.") ||
+		strings.HasPrefix(mediaType, "application/vnd.oci.image.index.")
+}
+
+func formatRegistryPlatform(os string, architecture string, variant string) string {
+	platform := os + "/" + architecture
+	if variant != "" {
+		platform += "/" + variant
+	}
+	return platform
+}
+
+// platformMatches compares platforms, ignoring the variant when wanted does
+// not specify one.
+func platformMatches(wanted string, platform string) bool {
+	if wanted == platform {
+		return true
+	}
+	return strings.Count(wanted, "/") == 1 && strings.HasPrefix(platform, wanted+"/")
+}
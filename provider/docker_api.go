@@ -0,0 +1,327 @@
+package provider
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	dc "github.com/fsouza/go-dockerclient"
+)
+
+// Directories where docker discovers legacy (v1) plugins, see
+// https://docs.docker.com/engine/extend/plugin_api/#plugin-discovery
+var dockerLegacyPluginPaths = []string{"/run/docker/plugins", "/etc/docker/plugins", "/usr/lib/docker/plugins"}
+
+// dockerAPIRequest sends a request to an endpoint of the Docker API that
+// go-dockerclient does not cover, reusing the transport of client.
+func dockerAPIRequest(client *dc.Client, method string, path string, query url.Values, body io.Reader, contentType string) (*http.Response, error) {
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	return dockerAPIRequestWithHeader(client, method, path, query, body, header)
+}
+
+// dockerAPIRequestWithHeader is dockerAPIRequest for requests that need
+// headers besides the content type.
+func dockerAPIRequestWithHeader(client *dc.Client, method string, path string, query url.Values, body io.Reader, header http.Header) (*http.Response, error) {
+	endpoint, err := url.Parse(client.Endpoint())
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse Docker endpoint %s: %s", client.Endpoint(), err)
+	}
+
+	u := url.URL{Path: path, RawQuery: query.Encode()}
+	switch endpoint.Scheme {
+	case "unix", "npipe":
+		// The transport dials the socket, the host is not used.
+		u.Scheme, u.Host = "http", "unix.sock"
+	case "https":
+		u.Scheme, u.Host = "https", endpoint.Host
+	default:
+		u.Scheme, u.Host = "http", endpoint.Host
+		if client.TLSConfig != nil {
+			u.Scheme = "https"
+		}
+	}
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	resp, err := client.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return nil, &dc.Error{Status: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+	return resp, nil
+}
+
+// dockerLogDrivers returns the logging drivers available on the daemon:
+// the ones it reports in its info, enabled logging plugins and, for a
+// local daemon, legacy plugins.
+func dockerLogDrivers(client *dc.Client) ([]string, error) {
+	resp, err := dockerAPIRequest(client, "GET", "/info", nil, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var info struct {
+		Plugins struct {
+			Log []string
+		}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, err
+	}
+	drivers := info.Plugins.Log
+
+	// The daemon only reports plugins it has already used.
+	plugins, err := client.ListPlugins(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	for _, plugin := range plugins {
+		if !plugin.Active {
+			continue
+		}
+		for _, t := range plugin.Config.Interface.Types {
+			if strings.HasPrefix(t, "docker.logdriver/") {
+				drivers = append(drivers, plugin.Name, strings.TrimSuffix(plugin.Name, ":latest"))
+			}
+		}
+	}
+
+	if strings.HasPrefix(client.Endpoint(), "unix://") {
+		drivers = append(drivers, dockerLegacyPlugins()...)
+	}
+	return drivers, nil
+}
+
+// dockerLegacyPlugins lists the legacy plugins found in the discovery
+// directories of the local host.
+func dockerLegacyPlugins() []string {
+	plugins := []string{}
+	for _, dir := range dockerLegacyPluginPaths {
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.Printf("[DEBUG] Unable to list legacy Docker plugins in %s: %s", dir, err)
+			}
+			continue
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			switch {
+			case entry.IsDir():
+				// Sockets may be in a directory named after the plugin.
+				if _, err := os.Stat(filepath.Join(dir, name, name+".sock")); err == nil {
+					plugins = append(plugins, name)
+				}
+			case filepath.Ext(name) == ".sock" || filepath.Ext(name) == ".spec" || filepath.Ext(name) == ".json":
+				plugins = append(plugins, strings.TrimSuffix(name, filepath.Ext(name)))
+			}
+		}
+	}
+	return plugins
+}
+
+// dockerStopContainer stops a container without a timeout, so that the
+// daemon waits for the stop timeout of the container or its own default.
+// go-dockerclient always sends one.
+func dockerStopContainer(client *dc.Client, id string) error {
+	resp, err := dockerAPIRequest(client, "POST", "/containers/"+id+"/stop", nil, nil, "")
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// dockerCommitContainer commits a container and returns the ID of the new
+// image. go-dockerclient always lets the daemon pause the container.
+func dockerCommitContainer(client *dc.Client, opts dc.CommitContainerOptions, pause bool) (string, error) {
+	query := url.Values{}
+	query.Set("container", opts.Container)
+	query.Set("repo", opts.Repository)
+	query.Set("tag", opts.Tag)
+	query.Set("comment", opts.Message)
+	query.Set("author", opts.Author)
+	query.Set("pause", strconv.FormatBool(pause))
+	for _, change := range opts.Changes {
+		query.Add("changes", change)
+	}
+
+	resp, err := dockerAPIRequest(client, "POST", "/commit", query, nil, "")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		ID string `json:"Id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	return result.ID, nil
+}
+
+// dockerPruneBuildCache removes the build cache, all of it or only the
+// dangling entries, and returns the space reclaimed.
+func dockerPruneBuildCache(client *dc.Client, all bool, until string) (int64, error) {
+	query := url.Values{}
+	if all {
+		query.Set("all", "1")
+	}
+	if until != "" {
+		filters, err := json.Marshal(map[string][]string{"until": {until}})
+		if err != nil {
+			return 0, err
+		}
+		query.Set("filters", string(filters))
+	}
+
+	resp, err := dockerAPIRequest(client, "POST", "/build/prune", query, nil, "")
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		SpaceReclaimed int64
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, err
+	}
+	return result.SpaceReclaimed, nil
+}
+
+// dockerBuildImage sends a build to the daemon and copies its JSON output
+// to opts.OutputStream. go-dockerclient has no squash or isolation option
+// and cannot turn off the removal of intermediate containers.
+func dockerBuildImage(client *dc.Client, opts dc.BuildImageOptions, squash bool, isolation string) error {
+	query := url.Values{}
+	query.Set("t", opts.Name)
+	query.Set("rm", strconv.FormatBool(opts.RmTmpContainer))
+	for k, v := range map[string]string{
+		"dockerfile":   opts.Dockerfile,
+		"target":       opts.Target,
+		"extrahosts":   opts.ExtraHosts,
+		"remote":       opts.Remote,
+		"platform":     opts.Platform,
+		"cpusetcpus":   opts.CPUSetCPUs,
+		"networkmode":  opts.NetworkMode,
+		"cgroupparent": opts.CgroupParent,
+		"isolation":    isolation,
+	} {
+		if v != "" {
+			query.Set(k, v)
+		}
+	}
+	for k, v := range map[string]int64{
+		"shmsize":   opts.ShmSize,
+		"memory":    opts.Memory,
+		"memswap":   opts.Memswap,
+		"cpushares": opts.CPUShares,
+		"cpuquota":  opts.CPUQuota,
+		"cpuperiod": opts.CPUPeriod,
+	} {
+		if v != 0 {
+			query.Set(k, strconv.FormatInt(v, 10))
+		}
+	}
+	for k, v := range map[string]bool{
+		"forcerm": opts.ForceRmTmpContainer,
+		"nocache": opts.NoCache,
+		"pull":    opts.Pull,
+		"squash":  squash,
+	} {
+		if v {
+			query.Set(k, "1")
+		}
+	}
+
+	buildArgs := map[string]string{}
+	for _, arg := range opts.BuildArgs {
+		buildArgs[arg.Name] = arg.Value
+	}
+	for k, v := range map[string]interface{}{
+		"labels":    opts.Labels,
+		"cachefrom": opts.CacheFrom,
+		"ulimits":   opts.Ulimits,
+		"buildargs": buildArgs,
+	} {
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		if s := string(encoded); s != "null" && s != "{}" && s != "[]" {
+			query.Set(k, s)
+		}
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/x-tar")
+	if len(opts.AuthConfigs.Configs) > 0 {
+		encoded, err := json.Marshal(opts.AuthConfigs.Configs)
+		if err != nil {
+			return err
+		}
+		header.Set("X-Registry-Config", base64.URLEncoding.EncodeToString(encoded))
+	}
+
+	resp, err := dockerAPIRequestWithHeader(client, "POST", "/build", query, opts.InputStream, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if opts.InactivityTimeout <= 0 {
+		_, err = io.Copy(opts.OutputStream, resp.Body)
+		return err
+	}
+	// The body is closed when the daemon stays silent for too long, which
+	// ends the copy.
+	timer := time.AfterFunc(opts.InactivityTimeout, func() { resp.Body.Close() })
+	buf := make([]byte, 32*1024)
+	for {
+		n, readErr := resp.Body.Read(buf)
+		if !timer.Stop() {
+			return fmt.Errorf("No build output for %s", opts.InactivityTimeout)
+		}
+		timer.Reset(opts.InactivityTimeout)
+		if n > 0 {
+			if _, err := opts.OutputStream.Write(buf[:n]); err != nil {
+				timer.Stop()
+				return err
+			}
+		}
+		if readErr == io.EOF {
+			timer.Stop()
+			return nil
+		}
+		if readErr != nil {
+			timer.Stop()
+			return readErr
+		}
+	}
+}
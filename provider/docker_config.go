@@ -0,0 +1,385 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	dc "github.com/fsouza/go-dockerclient"
+)
+
+// dockerHubRegistry is the server address docker uses for Docker Hub
+// credentials. They are also returned under "", the registry of images
+// without one.
+const dockerHubRegistry = "https://index.docker.io/v1/"
+
+// dockerCLIConfig is the part of the docker CLI config.json holding
+// registry credentials.
+type dockerCLIConfig struct {
+	Auths map[string]struct {
+		Auth          string `json:"auth"`
+		Username      string `json:"username"`
+		Password      string `json:"password"`
+		IdentityToken string `json:"identitytoken"`
+		RegistryToken string `json:"registrytoken"`
+	} `json:"auths"`
+	CredsStore  string            `json:"credsStore"`
+	CredHelpers map[string]string `json:"credHelpers"`
+}
+
+// dockerConfigPath returns the config.json docker would use: configFile
+// when set, otherwise $DOCKER_CONFIG/config.json or ~/.docker/config.json.
+func dockerConfigPath(configFile string) string {
+	if configFile != "" {
+		return configFile
+	}
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return filepath.Join(dir, "config.json")
+	}
+	home := os.Getenv("HOME")
+	if home == "" {
+		return ""
+	}
+	return filepath.Join(home, ".docker", "config.json")
+}
+
+// dockerConfigFile is a docker CLI config file. Its credential helpers are
+// only run for the registries credentials are asked for.
+type dockerConfigFile struct {
+	filename string
+	config   dockerCLIConfig
+}
+
+// readDockerConfig reads a docker CLI config file. A missing default config
+// file is read as an empty one, a missing configFile is an error.
+func readDockerConfig(configFile string) (*dockerConfigFile, error) {
+	filename := dockerConfigPath(configFile)
+	if filename == "" {
+		return &dockerConfigFile{}, nil
+	}
+	content, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) && configFile == "" {
+		return &dockerConfigFile{filename: filename}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read docker config %s: %s", filename, err)
+	}
+
+	c := &dockerConfigFile{filename: filename}
+	if err := json.Unmarshal(content, &c.config); err != nil {
+		return nil, fmt.Errorf("Unable to parse docker config %s: %s", filename, err)
+	}
+	return c, nil
+}
+
+// auth returns the credentials for a registry, as found in image names.
+// credHelpers take precedence over credsStore, which takes precedence over
+// auths. A failing credential helper is only logged, the registry may
+// well be reachable without credentials.
+func (c *dockerConfigFile) auth(registry string) (dc.AuthConfiguration, error) {
+	host := dockerRegistryKey(dockerRegistryHost(registry))
+	server := host
+	if host == dockerRegistryHost(dockerHubRegistry) {
+		server = dockerHubRegistry
+	}
+
+	helper := c.config.CredHelpers[host]
+	if helper == "" {
+		helper = c.config.CredsStore
+	}
+	if helper != "" {
+		auth, err := getDockerCredentials(helper, server)
+		if err != nil {
+			log.Printf("[WARN] Unable to get credentials for %s from docker-credential-%s: %s", server, helper, err)
+		} else if auth != (dc.AuthConfiguration{}) {
+			return auth, nil
+		}
+	}
+
+	for entryServer := range c.config.Auths {
+		if dockerRegistryKey(dockerRegistryHost(entryServer)) == host {
+			return c.authsEntry(entryServer)
+		}
+	}
+	return dc.AuthConfiguration{}, nil
+}
+
+// authsEntry decodes the credentials stored in auths for server.
+func (c *dockerConfigFile) authsEntry(server string) (dc.AuthConfiguration, error) {
+	entry := c.config.Auths[server]
+	auth := dc.AuthConfiguration{
+		Username:      entry.Username,
+		Password:      entry.Password,
+		ServerAddress: server,
+		IdentityToken: entry.IdentityToken,
+		RegistryToken: entry.RegistryToken,
+	}
+	if entry.Auth != "" {
+		decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+		if err != nil {
+			return auth, fmt.Errorf("Unable to decode auth for %s in %s: %s", server, c.filename, err)
+		}
+		parts := strings.SplitN(string(decoded), ":", 2)
+		if len(parts) != 2 {
+			return auth, fmt.Errorf("Invalid auth for %s in %s", server, c.filename)
+		}
+		auth.Username, auth.Password = parts[0], parts[1]
+	}
+	return auth, nil
+}
+
+// allAuths returns the credentials of every registry the config file
+// knows of, by registry host. Builds need them all, as the base images may
+// come from any registry.
+func (c *dockerConfigFile) allAuths() (map[string]dc.AuthConfiguration, error) {
+	servers := []string{}
+	for server := range c.config.Auths {
+		servers = append(servers, server)
+	}
+	// With a credsStore, auths only lists the registries it holds
+	// credentials for, so ask the store itself.
+	if c.config.CredsStore != "" {
+		list, err := listDockerCredentials(c.config.CredsStore)
+		if err != nil {
+			log.Printf("[WARN] Unable to list credentials in docker-credential-%s: %s", c.config.CredsStore, err)
+		}
+		servers = append(servers, list...)
+	}
+	for server := range c.config.CredHelpers {
+		servers = append(servers, server)
+	}
+
+	auths := make(map[string]dc.AuthConfiguration)
+	for _, server := range servers {
+		host := dockerRegistryHost(server)
+		if _, ok := auths[host]; ok {
+			continue
+		}
+		auth, err := c.auth(host)
+		if err != nil {
+			return nil, err
+		}
+		setDockerRegistryAuth(auths, server, auth)
+	}
+	return auths, nil
+}
+
+func setDockerRegistryAuth(auths map[string]dc.AuthConfiguration, server string, auth dc.AuthConfiguration) {
+	if auth == (dc.AuthConfiguration{}) {
+		return
+	}
+	host := dockerRegistryHost(server)
+	auths[host] = auth
+	if host == dockerRegistryHost(dockerHubRegistry) {
+		auths[""] = auth
+		auths["docker.io"] = auth
+	}
+}
+
+// dockerRegistryKey returns the host credentials of registry are stored
+// under, mapping the names of Docker Hub to the one of its config entry.
+func dockerRegistryKey(registry string) string {
+	switch registry {
+	case "", "docker.io", "index.docker.io", dockerHubRegistryHost:
+		return dockerRegistryHost(dockerHubRegistry)
+	}
+	return registry
+}
+
+// dockerRegistryHost reduces a config.json server address such as
+// "https://index.docker.io/v1/" to the host used in image names.
+func dockerRegistryHost(server string) string {
+	host := server
+	if i := strings.Index(host, "://"); i >= 0 {
+		host = host[i+3:]
+	}
+	if i := strings.Index(host, "/"); i >= 0 {
+		host = host[:i]
+	}
+	return host
+}
+
+// runDockerCredentialHelper runs docker-credential-<helper> <action> with
+// input on stdin, following the docker-credential-helpers protocol.
+func runDockerCredentialHelper(helper string, action string, input string) ([]byte, error) {
+	name := "docker-credential-" + helper
+	cmd := exec.Command(name, action)
+	cmd.Stdin = strings.NewReader(input)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stdout.String() + stderr.String())
+		return nil, fmt.Errorf("%s %s failed: %s: %s", name, action, err, msg)
+	}
+	return stdout.Bytes(), nil
+}
+
+func listDockerCredentials(helper string) ([]string, error) {
+	output, err := runDockerCredentialHelper(helper, "list", "")
+	if err != nil {
+		return nil, err
+	}
+	var servers map[string]string
+	if err := json.Unmarshal(output, &servers); err != nil {
+		return nil, fmt.Errorf("Invalid output from docker-credential-%s list: %s", helper, err)
+	}
+	var list []string
+	for server := range servers {
+		list = append(list, server)
+	}
+	return list, nil
+}
+
+func getDockerCredentials(helper string, server string) (dc.AuthConfiguration, error) {
+	output, err := runDockerCredentialHelper(helper, "get", server)
+	if err != nil {
+		// Helpers report missing credentials as an error
+		if strings.Contains(err.Error(), "credentials not found") {
+			log.Printf("[DEBUG] No credentials for %s in docker-credential-%s", server, helper)
+			return dc.AuthConfiguration{}, nil
+		}
+		return dc.AuthConfiguration{}, err
+	}
+
+	var creds struct {
+		ServerURL string
+		Username  string
+		Secret    string
+	}
+	if err := json.Unmarshal(output, &creds); err != nil {
+		return dc.AuthConfiguration{}, fmt.Errorf("Invalid output from docker-credential-%s get: %s", helper, err)
+	}
+
+	auth := dc.AuthConfiguration{ServerAddress: server}
+	// "<token>" marks an identity token rather than a password
+	if creds.Username == "<token>" {
+		auth.IdentityToken = creds.Secret
+	} else {
+		auth.Username = creds.Username
+		auth.Password = creds.Secret
+	}
+	return auth, nil
+}
+
+// registryAuthResolver looks up registry credentials as they are needed.
+// For a given registry, the auth blocks of a resource take precedence over
+// the registry_auth blocks of the provider, which take precedence over the
+// docker CLI config and its credential helpers.
+type registryAuthResolver struct {
+	auth         map[string]dc.AuthConfiguration
+	registryAuth []RegistryAuth
+	dockerConfig string
+	configs      map[string]*dockerConfigFile
+}
+
+func newRegistryAuthResolver(auth map[string]dc.AuthConfiguration, config *ProviderConfig) *registryAuthResolver {
+	return &registryAuthResolver{
+		auth:         auth,
+		registryAuth: config.RegistryAuth,
+		dockerConfig: config.DockerConfig,
+		configs:      make(map[string]*dockerConfigFile),
+	}
+}
+
+func (r *registryAuthResolver) readConfig(configFile string) (*dockerConfigFile, error) {
+	if c, ok := r.configs[configFile]; ok {
+		return c, nil
+	}
+	c, err := readDockerConfig(configFile)
+	if err != nil {
+		return nil, err
+	}
+	r.configs[configFile] = c
+	return c, nil
+}
+
+// resolve returns the credentials for registry, as found in image names.
+func (r *registryAuthResolver) resolve(registry string) (dc.AuthConfiguration, error) {
+	if auth, ok := r.auth[registry]; ok {
+		return auth, nil
+	}
+	key := dockerRegistryKey(dockerRegistryHost(registry))
+	for authRegistry, auth := range r.auth {
+		if dockerRegistryKey(dockerRegistryHost(authRegistry)) == key {
+			return auth, nil
+		}
+	}
+
+	// Within a registry_auth block, username, password and
+	// identity_token override what config_file holds for the address.
+	for _, entry := range r.registryAuth {
+		if entry.Address != registry && dockerRegistryKey(dockerRegistryHost(entry.Address)) != key {
+			continue
+		}
+		auth := dc.AuthConfiguration{ServerAddress: entry.Address}
+		if entry.ConfigFile != "" {
+			c, err := r.readConfig(entry.ConfigFile)
+			if err != nil {
+				return auth, err
+			}
+			fileAuth, err := c.auth(registry)
+			if err != nil {
+				return auth, err
+			}
+			if fileAuth != (dc.AuthConfiguration{}) {
+				auth = fileAuth
+			}
+		}
+		if entry.Username != "" {
+			auth.Username = entry.Username
+			auth.Password = entry.Password
+		}
+		if entry.IdentityToken != "" {
+			auth.IdentityToken = entry.IdentityToken
+		}
+		if auth.Username != "" || auth.IdentityToken != "" || auth.RegistryToken != "" {
+			return auth, nil
+		}
+		log.Printf("[WARN] No credentials found for registry_auth %s", entry.Address)
+	}
+
+	c, err := r.readConfig(r.dockerConfig)
+	if err != nil {
+		return dc.AuthConfiguration{}, err
+	}
+	return c.auth(registry)
+}
+
+// resolveAll returns the credentials of every known registry.
+func (r *registryAuthResolver) resolveAll() (map[string]dc.AuthConfiguration, error) {
+	c, err := r.readConfig(r.dockerConfig)
+	if err != nil {
+		return nil, err
+	}
+	auths, err := c.allAuths()
+	if err != nil {
+		return nil, err
+	}
+
+	registries := []string{}
+	for _, entry := range r.registryAuth {
+		registries = append(registries, entry.Address)
+	}
+	for registry := range r.auth {
+		registries = append(registries, registry)
+	}
+	for _, registry := range registries {
+		auth, err := r.resolve(registry)
+		if err != nil {
+			return nil, err
+		}
+		setDockerRegistryAuth(auths, registry, auth)
+		if auth != (dc.AuthConfiguration{}) {
+			auths[registry] = auth
+		}
+	}
+	return auths, nil
+}
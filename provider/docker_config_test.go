@@ -0,0 +1,208 @@
+package provider
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	dc "github.com/fsouza/go-dockerclient"
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+func writeTestFile(t *testing.T, dir string, name string, content string, mode os.FileMode) string {
+	filename := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(filename, []byte(content), mode); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func testDockerConfigAuth(username string, password string) string {
+	return base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+}
+
+func TestGetAuthConfigPrecedence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "docker-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dockerConfig := writeTestFile(t, dir, "config.json", `{"auths": {
+		"registry.example.com": {"auth": "`+testDockerConfigAuth("config", "config-pw")+`"},
+		"https://index.docker.io/v1/": {"auth": "`+testDockerConfigAuth("hub", "hub-pw")+`"}
+	}}`, 0600)
+	otherConfig := writeTestFile(t, dir, "other.json", `{"auths": {
+		"registry.example.com": {"identitytoken": "other-token"}
+	}}`, 0600)
+	emptyConfig := writeTestFile(t, dir, "empty.json", `{}`, 0600)
+
+	cases := []struct {
+		name         string
+		registry     string
+		auth         []interface{}
+		registryAuth []RegistryAuth
+		expected     dc.AuthConfiguration
+	}{
+		{
+			name:     "docker_config",
+			registry: "registry.example.com",
+			expected: dc.AuthConfiguration{Username: "config", Password: "config-pw", ServerAddress: "registry.example.com"},
+		},
+		{
+			name:     "docker_config for Docker Hub",
+			registry: "",
+			expected: dc.AuthConfiguration{Username: "hub", Password: "hub-pw", ServerAddress: "https://index.docker.io/v1/"},
+		},
+		{
+			name:     "no credentials",
+			registry: "other.example.com",
+			expected: dc.AuthConfiguration{},
+		},
+		{
+			name:     "registry_auth over docker_config",
+			registry: "registry.example.com",
+			registryAuth: []RegistryAuth{
+				{Address: "registry.example.com", Username: "provider", Password: "provider-pw"},
+			},
+			expected: dc.AuthConfiguration{Username: "provider", Password: "provider-pw", ServerAddress: "registry.example.com"},
+		},
+		{
+			name:     "registry_auth config_file over docker_config",
+			registry: "registry.example.com",
+			registryAuth: []RegistryAuth{
+				{Address: "registry.example.com", ConfigFile: otherConfig},
+			},
+			expected: dc.AuthConfiguration{IdentityToken: "other-token", ServerAddress: "registry.example.com"},
+		},
+		{
+			name:     "registry_auth username over its config_file",
+			registry: "registry.example.com",
+			registryAuth: []RegistryAuth{
+				{Address: "registry.example.com", ConfigFile: otherConfig, Username: "provider", Password: "provider-pw"},
+			},
+			expected: dc.AuthConfiguration{Username: "provider", Password: "provider-pw", IdentityToken: "other-token", ServerAddress: "registry.example.com"},
+		},
+		{
+			name:     "registry_auth without credentials falls back to docker_config",
+			registry: "registry.example.com",
+			registryAuth: []RegistryAuth{
+				{Address: "registry.example.com", ConfigFile: emptyConfig},
+			},
+			expected: dc.AuthConfiguration{Username: "config", Password: "config-pw", ServerAddress: "registry.example.com"},
+		},
+		{
+			name:     "registry_auth of another registry",
+			registry: "registry.example.com",
+			registryAuth: []RegistryAuth{
+				{Address: "other.example.com", Username: "provider", Password: "provider-pw"},
+			},
+			expected: dc.AuthConfiguration{Username: "config", Password: "config-pw", ServerAddress: "registry.example.com"},
+		},
+		{
+			name:     "auth over registry_auth and docker_config",
+			registry: "registry.example.com",
+			auth: []interface{}{
+				map[string]interface{}{"registry": "registry.example.com", "username": "resource", "password": "resource-pw"},
+			},
+			registryAuth: []RegistryAuth{
+				{Address: "registry.example.com", Username: "provider", Password: "provider-pw"},
+			},
+			expected: dc.AuthConfiguration{Username: "resource", Password: "resource-pw", ServerAddress: "registry.example.com"},
+		},
+		{
+			name:     "auth of another registry",
+			registry: "registry.example.com",
+			auth: []interface{}{
+				map[string]interface{}{"registry": "other.example.com", "username": "resource", "password": "resource-pw"},
+			},
+			registryAuth: []RegistryAuth{
+				{Address: "registry.example.com", Username: "provider", Password: "provider-pw"},
+			},
+			expected: dc.AuthConfiguration{Username: "provider", Password: "provider-pw", ServerAddress: "registry.example.com"},
+		},
+	}
+
+	for _, c := range cases {
+		d := schema.TestResourceDataRaw(t, resourceDockerImage().Schema, map[string]interface{}{
+			"name":     "app",
+			"registry": c.registry,
+			"auth":     c.auth,
+		})
+		config := &ProviderConfig{DockerConfig: dockerConfig, RegistryAuth: c.registryAuth}
+
+		authConfig, err := getAuthConfig(d, config, c.registry)
+		if err != nil {
+			t.Errorf("%s: %s", c.name, err)
+			continue
+		}
+		if auth := authConfig[c.registry]; !reflect.DeepEqual(auth, c.expected) {
+			t.Errorf("%s: expected %+v, got %+v", c.name, c.expected, auth)
+		}
+	}
+}
+
+func TestGetAuthConfigCredentialHelpers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "docker-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The helpers record the servers they are asked for
+	calls := filepath.Join(dir, "calls")
+	writeTestFile(t, dir, "docker-credential-good", `#!/bin/sh
+read server
+echo "good $1 $server" >> `+calls+`
+echo '{"ServerURL": "'$server'", "Username": "helper", "Secret": "helper-pw"}'
+`, 0755)
+	writeTestFile(t, dir, "docker-credential-broken", `#!/bin/sh
+read server
+echo "broken $1 $server" >> `+calls+`
+echo "keychain locked" >&2
+exit 1
+`, 0755)
+	dockerConfig := writeTestFile(t, dir, "config.json", `{
+		"credsStore": "broken",
+		"credHelpers": {"registry.example.com": "good", "other.example.com": "good"}
+	}`, 0600)
+
+	path := os.Getenv("PATH")
+	defer os.Setenv("PATH", path)
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+
+	d := schema.TestResourceDataRaw(t, resourceDockerImage().Schema, map[string]interface{}{
+		"name": "app",
+	})
+	config := &ProviderConfig{DockerConfig: dockerConfig}
+
+	authConfig, err := getAuthConfig(d, config, "registry.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := dc.AuthConfiguration{Username: "helper", Password: "helper-pw", ServerAddress: "registry.example.com"}
+	if auth := authConfig["registry.example.com"]; !reflect.DeepEqual(auth, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, auth)
+	}
+
+	// A failing credsStore is not fatal
+	authConfig, err = getAuthConfig(d, config, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth := authConfig[""]; auth != (dc.AuthConfiguration{}) {
+		t.Fatalf("expected no credentials for Docker Hub, got %+v", auth)
+	}
+
+	content, err := ioutil.ReadFile(calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedCalls := "good get registry.example.com\nbroken get https://index.docker.io/v1/\n"
+	if string(content) != expectedCalls {
+		t.Fatalf("expected helper calls %q, got %q", expectedCalls, string(content))
+	}
+}
@@ -22,9 +22,20 @@ type ProviderConfig struct {
 	KeyFile      string
 	CertPath     string
 	StoragePath  string
+	DockerConfig string
+	RegistryAuth []RegistryAuth
 	Ping         bool
 }
 
+// RegistryAuth holds the credentials of a provider registry_auth block.
+type RegistryAuth struct {
+	Address       string
+	Username      string
+	Password      string
+	ConfigFile    string
+	IdentityToken string
+}
+
 type ResourceDockerConfig struct {
 	Host         string
 	MachineName  string
@@ -110,13 +121,65 @@ func Provider() terraform.ResourceProvider {
 				Optional:    true,
 				Description: "Ping docker host on connect",
 			},
+
+			"docker_config": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Path to the docker CLI config.json with registry credentials, defaults to ~/.docker/config.json",
+			},
+
+			"registry_auth": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Description: "Registry credentials shared by all resources, taking precedence over docker_config and overridden by the auth blocks of a resource",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"address": {
+							Type:        schema.TypeString,
+							Required:    true,
+							Description: "Registry address, e.g. registry.example.com:5000",
+						},
+						"username": {
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"password": {
+							Type:      schema.TypeString,
+							Optional:  true,
+							Sensitive: true,
+						},
+						"config_file": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "docker CLI config.json to read the credentials of address from, overridden by username, password and identity_token",
+						},
+						"identity_token": {
+							Type:      schema.TypeString,
+							Optional:  true,
+							Sensitive: true,
+						},
+					},
+				},
+			},
 		},
 
 		ResourcesMap: map[string]*schema.Resource{
-			"dockerclient_container": resourceDockerContainer(),
-			"dockerclient_image":     resourceDockerImage(),
-			"dockerclient_network":   resourceDockerNetwork(),
-			"dockerclient_volume":    resourceDockerVolume(),
+			"dockerclient_container":        resourceDockerContainer(),
+			"dockerclient_container_commit": resourceDockerContainerCommit(),
+			"dockerclient_container_file":   resourceDockerContainerFile(),
+			"dockerclient_container_group":  resourceDockerContainerGroup(),
+			"dockerclient_image":            resourceDockerImage(),
+			"dockerclient_image_prune":      resourceDockerImagePrune(),
+			"dockerclient_image_tag":        resourceDockerImageTag(),
+			"dockerclient_network":          resourceDockerNetwork(),
+			"dockerclient_volume":           resourceDockerVolume(),
+		},
+
+		DataSourcesMap: map[string]*schema.Resource{
+			"dockerclient_container_file": dataSourceDockerContainerFile(),
+			"dockerclient_container_logs": dataSourceDockerContainerLogs(),
+			"dockerclient_image":          dataSourceDockerImage(),
+			"dockerclient_registry_image": dataSourceDockerRegistryImage(),
 		},
 
 		ConfigureFunc: providerConfigure,
@@ -124,6 +187,18 @@ func Provider() terraform.ResourceProvider {
 }
 
 func providerConfigure(d *schema.ResourceData) (interface{}, error) {
+	var registryAuth []RegistryAuth
+	for _, authIf := range d.Get("registry_auth").([]interface{}) {
+		auth := authIf.(map[string]interface{})
+		registryAuth = append(registryAuth, RegistryAuth{
+			Address:       auth["address"].(string),
+			Username:      auth["username"].(string),
+			Password:      auth["password"].(string),
+			ConfigFile:    auth["config_file"].(string),
+			IdentityToken: auth["identity_token"].(string),
+		})
+	}
+
 	return &ProviderConfig{
 		Host:        d.Get("default_host").(string),
 		MachineName: d.Get("default_machine_name").(string),
@@ -139,13 +214,26 @@ func providerConfigure(d *schema.ResourceData) (interface{}, error) {
 		CertPath:    d.Get("cert_path").(string),
 		StoragePath: d.Get("storage_path").(string),
 
+		DockerConfig: d.Get("docker_config").(string),
+		RegistryAuth: registryAuth,
+
 		Ping: d.Get("ping").(bool),
 	}, nil
 }
 
-func (c *ProviderConfig) GetResolvedConfig(d *schema.ResourceData) (*ProviderConfig, bool, error) {
+// ResourceConfig is implemented by both schema.ResourceData and
+// schema.ResourceDiff, so that connection settings can also be resolved
+// while planning.
+type ResourceConfig interface {
+	Get(key string) interface{}
+	GetOk(key string) (interface{}, bool)
+}
+
+func (c *ProviderConfig) GetResolvedConfig(d ResourceConfig) (*ProviderConfig, bool, error) {
 	r := &ProviderConfig{
-		Ping: c.Ping,
+		Ping:         c.Ping,
+		DockerConfig: c.DockerConfig,
+		RegistryAuth: c.RegistryAuth,
 	}
 	var certPath string
 
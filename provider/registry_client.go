@@ -0,0 +1,306 @@
+package provider
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	dc "github.com/fsouza/go-dockerclient"
+)
+
+const (
+	dockerHubRegistryHost = "registry-1.docker.io"
+	registryTimeout       = 30 * time.Second
+)
+
+// Manifest media types accepted from registries, manifest lists first so
+// that the digest matches the one docker records when pulling by tag.
+var registryManifestMediaTypes = []string{
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+}
+
+// registryManifest is a manifest fetched from a registry v2 API.
+type registryManifest struct {
+	Digest    string
+	MediaType string
+	Body      []byte
+}
+
+// registryClient talks to the v2 API of a single registry, negotiating
+// bearer tokens as needed.
+type registryClient struct {
+	host   string
+	scheme string
+	auth   dc.AuthConfiguration
+	client *http.Client
+	token  string
+}
+
+// newRegistryClient returns a client for registry, as found in an image
+// name. An empty registry is Docker Hub.
+func newRegistryClient(registry string, auth dc.AuthConfiguration) *registryClient {
+	host := registry
+	if host == "" || host == "docker.io" || host == "index.docker.io" {
+		host = dockerHubRegistryHost
+	}
+	return &registryClient{
+		host:   host,
+		scheme: "https",
+		auth:   auth,
+		client: &http.Client{Timeout: registryTimeout},
+	}
+}
+
+// registryRepository returns the repository path in the registry, adding
+// the library/ prefix of official Docker Hub images.
+func registryRepository(registry string, repository string) string {
+	if (registry == "" || registry == "docker.io" || registry == "index.docker.io") && !strings.Contains(repository, "/") {
+		return "library/" + repository
+	}
+	return repository
+}
+
+// isLocalRegistry reports whether host is served on the loopback
+// interface, where docker also allows plain HTTP.
+func isLocalRegistry(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// fetchManifest returns the manifest of repository:reference. With head
+// only the digest and media type are fetched.
+func (c *registryClient) fetchManifest(repository string, reference string, head bool) (*registryManifest, error) {
+	method := "GET"
+	if head {
+		method = "HEAD"
+	}
+	resp, err := c.do(method, "/v2/"+repository+"/manifests/"+reference, strings.Join(registryManifestMediaTypes, ", "), repository)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unable to fetch manifest %s:%s from %s: %s %s", repository, reference, c.host, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	manifest := &registryManifest{
+		Digest:    resp.Header.Get("Docker-Content-Digest"),
+		MediaType: resp.Header.Get("Content-Type"),
+		Body:      body,
+	}
+	if manifest.Digest == "" && !head {
+		sum := sha256.Sum256(body)
+		manifest.Digest = "sha256:" + hex.EncodeToString(sum[:])
+	}
+	if manifest.Digest == "" {
+		return nil, fmt.Errorf("Registry %s did not report the digest of %s:%s", c.host, repository, reference)
+	}
+	return manifest, nil
+}
+
+// fetchBlob returns the content of a blob, e.g. an image config.
+func (c *registryClient) fetchBlob(repository string, digest string) ([]byte, error) {
+	resp, err := c.do("GET", "/v2/"+repository+"/blobs/"+digest, "", repository)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unable to fetch blob %s of %s from %s: %s", digest, repository, c.host, resp.Status)
+	}
+	return body, nil
+}
+
+// do sends a request, answering an authentication challenge once.
+func (c *registryClient) do(method string, path string, accept string, repository string) (*http.Response, error) {
+	resp, err := c.send(method, path, accept)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		return resp, nil
+	}
+
+	challenge := resp.Header.Get("WWW-Authenticate")
+	resp.Body.Close()
+	scheme, params := parseAuthChallenge(challenge)
+	switch strings.ToLower(scheme) {
+	case "bearer":
+		if params["scope"] == "" {
+			params["scope"] = "repository:" + repository + ":pull"
+		}
+		if err := c.fetchToken(params); err != nil {
+			return nil, err
+		}
+	case "basic":
+		// Credentials, if any, were already sent
+		if c.auth.Username == "" {
+			return nil, fmt.Errorf("Registry %s requires credentials", c.host)
+		}
+		return nil, fmt.Errorf("Registry %s rejected the credentials of %s", c.host, c.auth.Username)
+	default:
+		return nil, fmt.Errorf("Unsupported authentication challenge from %s: %q", c.host, challenge)
+	}
+	return c.send(method, path, accept)
+}
+
+func (c *registryClient) send(method string, path string, accept string) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.scheme+"://"+c.host+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	switch {
+	case c.token != "":
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	case c.auth.RegistryToken != "":
+		req.Header.Set("Authorization", "Bearer "+c.auth.RegistryToken)
+	case c.auth.Username != "":
+		req.SetBasicAuth(c.auth.Username, c.auth.Password)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil && c.scheme == "https" && isLocalRegistry(c.host) {
+		// Local registries are commonly served over plain HTTP
+		c.scheme = "http"
+		return c.send(method, path, accept)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to reach registry %s: %s", c.host, err)
+	}
+	return resp, nil
+}
+
+// fetchToken obtains a bearer token from the realm of the challenge, using
+// the identity token as an OAuth2 refresh token when there is one.
+func (c *registryClient) fetchToken(params map[string]string) error {
+	realm := params["realm"]
+	if realm == "" {
+		return fmt.Errorf("Registry %s sent a bearer challenge without realm", c.host)
+	}
+
+	var req *http.Request
+	var err error
+	if c.auth.IdentityToken != "" {
+		form := url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {c.auth.IdentityToken},
+			"service":       {params["service"]},
+			"scope":         {params["scope"]},
+			"client_id":     {"terraform-provider-dockerclient"},
+		}
+		req, err = http.NewRequest("POST", realm, strings.NewReader(form.Encode()))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		query := url.Values{}
+		if params["service"] != "" {
+			query.Set("service", params["service"])
+		}
+		query.Set("scope", params["scope"])
+		sep := "?"
+		if strings.Contains(realm, "?") {
+			sep = "&"
+		}
+		req, err = http.NewRequest("GET", realm+sep+query.Encode(), nil)
+		if err != nil {
+			return err
+		}
+		if c.auth.Username != "" {
+			req.SetBasicAuth(c.auth.Username, c.auth.Password)
+		}
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Unable to get a token from %s: %s", realm, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Unable to get a token from %s: %s", realm, resp.Status)
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return fmt.Errorf("Invalid token response from %s: %s", realm, err)
+	}
+	c.token = token.Token
+	if c.token == "" {
+		c.token = token.AccessToken
+	}
+	if c.token == "" {
+		return fmt.Errorf("Empty token from %s", realm)
+	}
+	return nil
+}
+
+// parseAuthChallenge splits a WWW-Authenticate header such as
+// `Bearer realm="https://auth.docker.io/token",service="registry.docker.io"`.
+func parseAuthChallenge(header string) (string, map[string]string) {
+	params := make(map[string]string)
+	parts := strings.SplitN(strings.TrimSpace(header), " ", 2)
+	if len(parts) < 2 {
+		return parts[0], params
+	}
+
+	rest := parts[1]
+	for rest != "" {
+		eq := strings.Index(rest, "=")
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(rest[:eq]))
+		rest = strings.TrimSpace(rest[eq+1:])
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else if comma := strings.Index(rest, ","); comma >= 0 {
+			value, rest = rest[:comma], rest[comma:]
+		} else {
+			value, rest = rest, ""
+		}
+		params[key] = value
+		rest = strings.TrimPrefix(strings.TrimSpace(rest), ",")
+		rest = strings.TrimSpace(rest)
+	}
+	return parts[0], params
+}
@@ -0,0 +1,267 @@
+package provider
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	dc "github.com/fsouza/go-dockerclient"
+	"github.com/hashicorp/terraform/config"
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+const testRegistryManifest = `{"schemaVersion": 2}`
+const testRegistryDigest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+// fakeRegistry serves a single manifest, library/app:latest, behind a
+// bearer token issued by its /token endpoint.
+type fakeRegistry struct {
+	t           *testing.T
+	server      *httptest.Server
+	tokenCheck  func(r *http.Request) bool
+	methods     []string
+	sendDigest  bool
+	tokenIssued int
+}
+
+func newFakeRegistry(t *testing.T, tokenCheck func(r *http.Request) bool) *fakeRegistry {
+	f := &fakeRegistry{t: t, tokenCheck: tokenCheck, sendDigest: true}
+	f.server = httptest.NewServer(f)
+	return f
+}
+
+func (f *fakeRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/token":
+		if !f.tokenCheck(r) {
+			http.Error(w, "invalid credentials", http.StatusUnauthorized)
+			return
+		}
+		f.tokenIssued++
+		fmt.Fprint(w, `{"token": "registry-token"}`)
+	case "/v2/library/app/manifests/latest":
+		f.methods = append(f.methods, r.Method)
+		if r.Header.Get("Authorization") != "Bearer registry-token" {
+			w.Header().Set("WWW-Authenticate",
+				`Bearer realm="`+f.server.URL+`/token",service="registry.test",scope="repository:library/app:pull"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !strings.Contains(r.Header.Get("Accept"), "application/vnd.docker.distribution.manifest.list.v2+json") {
+			f.t.Errorf("manifest list not accepted: %s", r.Header.Get("Accept"))
+		}
+		w.Header().Set("Content-Type", "application/vnd.docker.distribution.manifest.v2+json")
+		if f.sendDigest {
+			w.Header().Set("Docker-Content-Digest", testRegistryDigest)
+		}
+		if r.Method != "HEAD" {
+			fmt.Fprint(w, testRegistryManifest)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeRegistry) client(auth dc.AuthConfiguration) *registryClient {
+	u, _ := url.Parse(f.server.URL)
+	return &registryClient{host: u.Host, scheme: "http", auth: auth, client: &http.Client{}}
+}
+
+func TestRegistryClientBearerToken(t *testing.T) {
+	registry := newFakeRegistry(t, func(r *http.Request) bool {
+		username, password, _ := r.BasicAuth()
+		return r.Method == "GET" &&
+			r.URL.Query().Get("service") == "registry.test" &&
+			r.URL.Query().Get("scope") == "repository:library/app:pull" &&
+			username == "user" && password == "secret"
+	})
+	defer registry.server.Close()
+
+	client := registry.client(dc.AuthConfiguration{Username: "user", Password: "secret"})
+	manifest, err := client.fetchManifest("library/app", "latest", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Digest != testRegistryDigest || string(manifest.Body) != testRegistryManifest ||
+		manifest.MediaType != "application/vnd.docker.distribution.manifest.v2+json" {
+		t.Fatalf("unexpected manifest %+v", manifest)
+	}
+
+	// The token is reused for later requests
+	if _, err := client.fetchManifest("library/app", "latest", false); err != nil {
+		t.Fatal(err)
+	}
+	if registry.tokenIssued != 1 {
+		t.Fatalf("expected a single token request, got %d", registry.tokenIssued)
+	}
+}
+
+func TestRegistryClientBearerTokenRejected(t *testing.T) {
+	registry := newFakeRegistry(t, func(r *http.Request) bool { return false })
+	defer registry.server.Close()
+
+	client := registry.client(dc.AuthConfiguration{Username: "user", Password: "wrong"})
+	if _, err := client.fetchManifest("library/app", "latest", false); err == nil {
+		t.Fatal("expected an error when no token is issued")
+	}
+}
+
+func TestRegistryClientIdentityToken(t *testing.T) {
+	registry := newFakeRegistry(t, func(r *http.Request) bool {
+		if err := r.ParseForm(); err != nil {
+			return false
+		}
+		_, _, basic := r.BasicAuth()
+		return r.Method == "POST" && !basic &&
+			r.PostForm.Get("grant_type") == "refresh_token" &&
+			r.PostForm.Get("refresh_token") == "identity-token" &&
+			r.PostForm.Get("service") == "registry.test" &&
+			r.PostForm.Get("scope") == "repository:library/app:pull" &&
+			r.PostForm.Get("client_id") != ""
+	})
+	defer registry.server.Close()
+
+	client := registry.client(dc.AuthConfiguration{Username: "user", IdentityToken: "identity-token"})
+	manifest, err := client.fetchManifest("library/app", "latest", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Digest != testRegistryDigest {
+		t.Fatalf("expected digest %s, got %s", testRegistryDigest, manifest.Digest)
+	}
+}
+
+func TestRegistryClientHeadDigest(t *testing.T) {
+	registry := newFakeRegistry(t, func(r *http.Request) bool { return true })
+	defer registry.server.Close()
+
+	client := registry.client(dc.AuthConfiguration{})
+	manifest, err := client.fetchManifest("library/app", "latest", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Digest != testRegistryDigest || len(manifest.Body) != 0 {
+		t.Fatalf("unexpected manifest %+v", manifest)
+	}
+	if !reflect.DeepEqual(registry.methods, []string{"HEAD", "HEAD"}) {
+		t.Fatalf("expected only HEAD requests, got %v", registry.methods)
+	}
+
+	// Without Docker-Content-Digest a HEAD request cannot tell the digest,
+	// a GET request computes it from the manifest.
+	registry.sendDigest = false
+	if _, err := client.fetchManifest("library/app", "latest", true); err == nil {
+		t.Fatal("expected an error without Docker-Content-Digest")
+	}
+	manifest, err = client.fetchManifest("library/app", "latest", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte(testRegistryManifest))
+	if expected := "sha256:" + hex.EncodeToString(sum[:]); manifest.Digest != expected {
+		t.Fatalf("expected digest %s, got %s", expected, manifest.Digest)
+	}
+}
+
+func TestRegistryClientBasicChallenge(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+
+	client := &registryClient{host: u.Host, scheme: "http", client: &http.Client{}}
+	_, err := client.fetchManifest("library/app", "latest", true)
+	if err == nil || !strings.Contains(err.Error(), "requires credentials") {
+		t.Fatalf("expected credentials to be required, got %v", err)
+	}
+}
+
+func TestParseAuthChallenge(t *testing.T) {
+	cases := []struct {
+		header string
+		scheme string
+		params map[string]string
+	}{
+		{
+			`Bearer realm="https://auth.docker.io/token",service="registry.docker.io"`,
+			"Bearer",
+			map[string]string{"realm": "https://auth.docker.io/token", "service": "registry.docker.io"},
+		},
+		{
+			`Bearer realm="https://auth.example.com/token",service="registry.example.com",scope="repository:team/app:pull,push"`,
+			"Bearer",
+			map[string]string{"realm": "https://auth.example.com/token", "service": "registry.example.com", "scope": "repository:team/app:pull,push"},
+		},
+		{
+			`Bearer Realm="https://auth.example.com/token", service=registry.example.com`,
+			"Bearer",
+			map[string]string{"realm": "https://auth.example.com/token", "service": "registry.example.com"},
+		},
+		{
+			`Basic realm="Registry Realm"`,
+			"Basic",
+			map[string]string{"realm": "Registry Realm"},
+		},
+		{
+			`Basic`,
+			"Basic",
+			map[string]string{},
+		},
+	}
+
+	for _, c := range cases {
+		scheme, params := parseAuthChallenge(c.header)
+		if scheme != c.scheme || !reflect.DeepEqual(params, c.params) {
+			t.Errorf("%s: expected %s %v, got %s %v", c.header, c.scheme, c.params, scheme, params)
+		}
+	}
+}
+
+// The digest found on refresh is compared with the local ones, and a new
+// pull is planned when the tag moved.
+func TestResourceDockerImageCustomizeDiffRemoteDigest(t *testing.T) {
+	cases := []struct {
+		remoteDigest string
+		pull         bool
+	}{
+		{testRegistryDigest, false},
+		{"sha256:moved", true},
+	}
+
+	for _, c := range cases {
+		settings := map[string]interface{}{
+			"name":                "app",
+			"pull":                true,
+			"track_remote_digest": true,
+		}
+		d := schema.TestResourceDataRaw(t, resourceDockerImage().Schema, settings)
+		d.SetId("app:latest")
+		d.Set("id", "sha256:image")
+		d.Set("digests", []string{"app@" + testRegistryDigest})
+		d.Set("remote_digest", c.remoteDigest)
+		state := d.State()
+
+		raw, err := config.NewRawConfig(settings)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		diff, err := resourceDockerImage().Diff(state, terraform.NewResourceConfig(raw), &ProviderConfig{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pull := diff != nil && diff.RequiresNew()
+		if pull != c.pull {
+			t.Errorf("remote digest %s: expected a new pull to be %v, got %v", c.remoteDigest, c.pull, pull)
+		}
+	}
+}
@@ -5,12 +5,24 @@ package provider
 
 import (
 	"archive/tar"
+	"bufio"
 	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
+	"unicode"
 
 	"github.com/hashicorp/terraform/helper/hashcode"
 	"github.com/hashicorp/terraform/helper/schema"
@@ -30,6 +42,8 @@ func resourceDockerContainer() *schema.Resource {
 		Delete: resourceDockerContainerDelete,
 		Exists: resourceDockerContainerExists,
 
+		CustomizeDiff: resourceDockerContainerCustomizeDiff,
+
 		Schema: map[string]*schema.Schema{
 			"name": {
 				Type:     schema.TypeString,
@@ -137,6 +151,54 @@ func resourceDockerContainer() *schema.Resource {
 				Elem:     &schema.Schema{Type: schema.TypeString},
 			},
 
+			"working_dir": {
+				Type:     schema.TypeString,
+				Optional: true,
+				Computed: true,
+				ForceNew: true,
+			},
+
+			"shell": {
+				Type:     schema.TypeList,
+				Optional: true,
+				Computed: true,
+				ForceNew: true,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+			},
+
+			"on_build": {
+				Type:     schema.TypeList,
+				Optional: true,
+				Computed: true,
+				ForceNew: true,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+			},
+
+			"tty": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				ForceNew: true,
+			},
+
+			"stdin_open": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				ForceNew: true,
+			},
+
+			"stdin_once": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				ForceNew: true,
+			},
+
+			"mac_address": {
+				Type:     schema.TypeString,
+				Optional: true,
+				Computed: true,
+				ForceNew: true,
+			},
+
 			"dns": {
 				Type:     schema.TypeSet,
 				Optional: true,
@@ -321,6 +383,29 @@ func resourceDockerContainer() *schema.Resource {
 				Set:      schema.HashString,
 			},
 
+			"env_map": {
+				Type:     schema.TypeMap,
+				Optional: true,
+				ForceNew: true,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+			},
+
+			// Local file in the format accepted by "docker run --env-file".
+			// Variables from env_map and env take precedence over it.
+			"env_file": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: true,
+			},
+
+			// Digest of the variables read from env_file, so that editing
+			// the file replaces the container too.
+			"env_file_sha256": {
+				Type:     schema.TypeString,
+				Computed: true,
+				ForceNew: true,
+			},
+
 			"links": {
 				Type:     schema.TypeSet,
 				Optional: true,
@@ -360,6 +445,75 @@ func resourceDockerContainer() *schema.Resource {
 				Optional: true,
 			},
 
+			// Signal Docker sends to stop the container, e.g. "SIGQUIT".
+			"stop_signal": {
+				Type:     schema.TypeString,
+				Optional: true,
+				Computed: true,
+				ForceNew: true,
+			},
+
+			// Seconds Docker waits after stop_signal before killing the
+			// container. Also used on destroy when destroy_grace_seconds
+			// is not set.
+			"stop_timeout": {
+				Type:     schema.TypeInt,
+				Optional: true,
+				ForceNew: true,
+				ValidateFunc: func(v interface{}, k string) (ws []string, es []error) {
+					value := v.(int)
+					if value < 0 {
+						es = append(es, fmt.Errorf("%q must be greater than or equal to 0", k))
+					}
+					return
+				},
+			},
+
+			// Command run inside the container before it is stopped on
+			// destroy, e.g. to drain connections.
+			"pre_stop": {
+				Type:     schema.TypeList,
+				Optional: true,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+			},
+
+			// Replace the container blue/green style when the
+			// create_before_destroy lifecycle setting is used, instead of
+			// failing because the name and host ports are still taken.
+			// Only a container created by this provider is replaced.
+			"blue_green": {
+				Type:     schema.TypeBool,
+				Optional: true,
+			},
+
+			// Seconds to wait for a new container to be running, and
+			// healthy when the image defines a health check, before the
+			// container it replaces is stopped.
+			"readiness_timeout": {
+				Type:     schema.TypeInt,
+				Optional: true,
+				Default:  60,
+			},
+
+			// Export the filesystem of the container to a tar archive
+			// once it is running.
+			"export_path": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+
+			"export_sha256": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+
+			// Remove the anonymous volumes of the container on destroy.
+			"remove_volumes": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  true,
+			},
+
 			"labels": {
 				Type:     schema.TypeMap,
 				Optional: true,
@@ -405,25 +559,20 @@ func resourceDockerContainer() *schema.Resource {
 				},
 			},
 
+			// log_driver must be a logging driver reported by the daemon,
+			// an enabled logging plugin or a legacy plugin, which is
+			// checked at plan time. Together with log_opts it forces a new
+			// container only when the configuration actually differs from
+			// the running one, see resourceDockerContainerCustomizeDiff.
 			"log_driver": {
 				Type:     schema.TypeString,
 				Optional: true,
-				ForceNew: true,
 				Default:  "json-file",
-				ValidateFunc: func(v interface{}, k string) (ws []string, es []error) {
-					value := v.(string)
-					if !regexp.MustCompile(`^(json-file|syslog|journald|gelf|fluentd)$`).MatchString(value) {
-						es = append(es, fmt.Errorf(
-							"%q must be one of \"json-file\", \"syslog\", \"journald\", \"gelf\", or \"fluentd\"", k))
-					}
-					return
-				},
 			},
 
 			"log_opts": {
 				Type:     schema.TypeMap,
 				Optional: true,
-				ForceNew: true,
 			},
 
 			"network_alias": {
@@ -456,24 +605,226 @@ func resourceDockerContainer() *schema.Resource {
 					Schema: map[string]*schema.Schema{
 						"content": {
 							Type:     schema.TypeString,
-							Required: true,
+							Optional: true,
 							// This is intentional. The container is mutated once, and never updated later.
 							// New configuration forces a new deployment, even with the same binaries.
 							ForceNew: true,
 						},
+						"content_base64": {
+							Type:     schema.TypeString,
+							Optional: true,
+							ForceNew: true,
+						},
+						// Local file or directory to copy into the container. Directories
+						// are copied recursively below file.
+						"source": {
+							Type:     schema.TypeString,
+							Optional: true,
+							ForceNew: true,
+						},
 						"file": {
 							Type:     schema.TypeString,
 							Required: true,
 							ForceNew: true,
 						},
+						"mode": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							ForceNew:     true,
+							ValidateFunc: validateDockerFileMode,
+						},
+						"uid": {
+							Type:     schema.TypeInt,
+							Optional: true,
+							ForceNew: true,
+						},
+						"gid": {
+							Type:     schema.TypeInt,
+							Optional: true,
+							ForceNew: true,
+						},
+						"executable": {
+							Type:     schema.TypeBool,
+							Optional: true,
+							ForceNew: true,
+						},
 					},
 				},
 				Set: resourceDockerUploadHash,
 			},
+
+			// Digest of the files behind the upload sources, so that editing
+			// them replaces the container too.
+			"upload_sha256": {
+				Type:     schema.TypeString,
+				Computed: true,
+				ForceNew: true,
+			},
 		},
 	}
 }
 
+func resourceDockerContainerCustomizeDiff(d *schema.ResourceDiff, meta interface{}) error {
+	if err := validateDockerUploads(d); err != nil {
+		return err
+	}
+	if err := setDockerUploadDigest(d); err != nil {
+		return err
+	}
+	if err := setDockerEnvFileDigest(d); err != nil {
+		return err
+	}
+	if err := validateDockerLogConfig(d, meta); err != nil {
+		return err
+	}
+
+	// The daemon adds its default log options to containers using the
+	// default driver, so only replace the container when a configured
+	// value differs from the one in use or is removed.
+	if d.Id() != "" && (d.HasChange("log_driver") || d.HasChange("log_opts")) {
+		if dockerLogConfigChanged(d) {
+			for _, k := range []string{"log_driver", "log_opts"} {
+				if d.HasChange(k) {
+					if err := d.ForceNew(k); err != nil {
+						return err
+					}
+				}
+			}
+		} else if d.HasChange("log_opts") {
+			if err := d.Clear("log_opts"); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func dockerLogConfigChanged(d *schema.ResourceDiff) bool {
+	oldDriver, newDriver := d.GetChange("log_driver")
+	if oldDriver.(string) != newDriver.(string) {
+		return true
+	}
+
+	oldOpts, newOpts := d.GetChange("log_opts")
+	for k, v := range newOpts.(map[string]interface{}) {
+		if old, ok := oldOpts.(map[string]interface{})[k]; !ok || old != v {
+			return true
+		}
+	}
+	// Only configured keys are kept in the state, see
+	// dockerTrackedLogOpts, so a missing one was removed.
+	for k := range oldOpts.(map[string]interface{}) {
+		if _, ok := newOpts.(map[string]interface{})[k]; !ok {
+			return true
+		}
+	}
+	return false
+}
+
+// dockerTrackedLogOpts returns the log options of a container that are
+// tracked in the state. The daemon adds its default options to the
+// configured ones, so only the configured keys are kept and removing
+// log_opts from the configuration shows up as a change.
+func dockerTrackedLogOpts(d *schema.ResourceData, opts map[string]string) map[string]string {
+	tracked := d.Get("log_opts").(map[string]interface{})
+	result := map[string]string{}
+	for k := range tracked {
+		if v, ok := opts[k]; ok {
+			result[k] = v
+		}
+	}
+	return result
+}
+
+// Known log_opts keys of the logging drivers built into Docker, checked
+// once the daemon confirms the driver. Drivers provided by plugins are not
+// checked.
+var dockerLogDriverOpts = map[string][]string{
+	"none":      {},
+	"json-file": {"max-size", "max-file", "compress", "labels", "labels-regex", "env", "env-regex", "tag"},
+	"local":     {"max-size", "max-file", "compress"},
+	"syslog": {"syslog-address", "syslog-facility", "syslog-tls-ca-cert", "syslog-tls-cert", "syslog-tls-key",
+		"syslog-tls-skip-verify", "syslog-format", "labels", "labels-regex", "env", "env-regex", "tag"},
+	"journald": {"labels", "labels-regex", "env", "env-regex", "tag"},
+	"gelf": {"gelf-address", "gelf-compression-type", "gelf-compression-level", "gelf-tcp-max-reconnect",
+		"gelf-tcp-reconnect-delay", "labels", "labels-regex", "env", "env-regex", "tag"},
+	"fluentd": {"fluentd-address", "fluentd-async", "fluentd-async-connect", "fluentd-buffer-limit",
+		"fluentd-retry-wait", "fluentd-max-retries", "fluentd-sub-second-precision", "fluentd-request-ack",
+		"labels", "labels-regex", "env", "env-regex", "tag"},
+	"awslogs": {"awslogs-region", "awslogs-endpoint", "awslogs-group", "awslogs-stream", "awslogs-create-group",
+		"awslogs-create-stream", "awslogs-datetime-format", "awslogs-multiline-pattern",
+		"awslogs-credentials-endpoint", "awslogs-force-flush-interval-seconds", "awslogs-max-buffered-events",
+		"awslogs-format", "tag"},
+	"splunk": {"splunk-token", "splunk-url", "splunk-source", "splunk-sourcetype", "splunk-index",
+		"splunk-capath", "splunk-caname", "splunk-insecureskipverify", "splunk-format",
+		"splunk-verify-connection", "splunk-gzip", "splunk-gzip-level", "splunk-index-acknowledgment",
+		"labels", "labels-regex", "env", "env-regex", "tag"},
+	"gcplogs": {"gcp-project", "gcp-log-cmd", "gcp-meta-zone", "gcp-meta-name", "gcp-meta-id",
+		"labels", "labels-regex", "env", "env-regex"},
+	"logentries": {"logentries-token", "line-only"},
+	"etwlogs":    {},
+}
+
+// Log options accepted by every driver.
+var dockerLogCommonOpts = []string{"mode", "max-buffer-size"}
+
+func validateDockerLogConfig(d *schema.ResourceDiff, meta interface{}) error {
+	driver := d.Get("log_driver").(string)
+	if driver == "" {
+		return nil
+	}
+
+	// The driver must be available on the daemon, either built in or
+	// provided by a plugin. Connection settings may not be known until
+	// apply.
+	providerConfig := meta.(*ProviderConfig)
+	resolvedConfig, deferred, err := providerConfig.GetResolvedConfig(d)
+	if deferred {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	client, err := resolvedConfig.NewClient()
+	if err != nil {
+		return err
+	}
+
+	drivers, err := dockerLogDrivers(client)
+	if err != nil {
+		return fmt.Errorf("Unable to list Docker logging drivers: %s", err)
+	}
+	found := false
+	for _, name := range drivers {
+		if name == driver {
+			found = true
+			break
+		}
+	}
+	if !found {
+		sort.Strings(drivers)
+		return fmt.Errorf("log_driver %q is not available on the Docker daemon, available drivers are: %s",
+			driver, strings.Join(drivers, ", "))
+	}
+
+	if keys, ok := dockerLogDriverOpts[driver]; ok {
+		known := map[string]bool{}
+		for _, k := range append(keys, dockerLogCommonOpts...) {
+			known[k] = true
+		}
+		for k := range d.Get("log_opts").(map[string]interface{}) {
+			if !known[k] {
+				supported := append([]string{}, keys...)
+				sort.Strings(supported)
+				return fmt.Errorf("log_opts key %q is not supported by the %s log driver, supported keys are: %s",
+					k, driver, strings.Join(append(supported, dockerLogCommonOpts...), ", "))
+			}
+		}
+	}
+	return nil
+}
+
 func resourceDockerCapabilitiesHash(v interface{}) int {
 	var buf bytes.Buffer
 	m := v.(map[string]interface{})
@@ -560,13 +911,48 @@ func resourceDockerUploadHash(v interface{}) int {
 		buf.WriteString(fmt.Sprintf("%v-", v.(string)))
 	}
 
+	if v, ok := m["content_base64"]; ok {
+		buf.WriteString(fmt.Sprintf("%v-", v.(string)))
+	}
+
+	if v, ok := m["source"]; ok {
+		buf.WriteString(fmt.Sprintf("%v-", v.(string)))
+	}
+
 	if v, ok := m["file"]; ok {
 		buf.WriteString(fmt.Sprintf("%v-", v.(string)))
 	}
 
+	if v, ok := m["mode"]; ok {
+		buf.WriteString(fmt.Sprintf("%v-", v.(string)))
+	}
+
+	if v, ok := m["uid"]; ok {
+		buf.WriteString(fmt.Sprintf("%v-", v.(int)))
+	}
+
+	if v, ok := m["gid"]; ok {
+		buf.WriteString(fmt.Sprintf("%v-", v.(int)))
+	}
+
+	if v, ok := m["executable"]; ok {
+		buf.WriteString(fmt.Sprintf("%v-", v.(bool)))
+	}
+
 	return hashcode.String(buf.String())
 }
 
+func validateDockerFileMode(v interface{}, k string) (ws []string, es []error) {
+	value := v.(string)
+	if value == "" {
+		return
+	}
+	if mode, err := strconv.ParseUint(value, 8, 32); err != nil || mode > 07777 {
+		es = append(es, fmt.Errorf("%q must be an octal file mode such as \"0644\"", k))
+	}
+	return
+}
+
 func validateDockerContainerPath(v interface{}, k string) (ws []string, errors []error) {
 
 	value := v.(string)
@@ -588,42 +974,221 @@ func resourceDockerContainerCreate(d *schema.ResourceData, meta interface{}) err
 		return err
 	}
 
-	var data Data
-	if err := fetchLocalImages(&data, client); err != nil {
+	if err := setDockerContainerFileDigests(d); err != nil {
 		return err
 	}
 
-	image := d.Get("image").(string)
-	if _, ok := data.DockerImages[image]; !ok {
-		if _, ok := data.DockerImages[image+":latest"]; !ok {
-			return fmt.Errorf("Unable to find image %s", image)
+	if d.Get("blue_green").(bool) {
+		old, err := client.InspectContainer(d.Get("name").(string))
+		if err != nil {
+			if _, ok := err.(*dc.NoSuchContainer); !ok {
+				return fmt.Errorf("Error inspecting container %s: %s", d.Get("name").(string), err)
+			}
+		}
+		// Only a container created by this provider is replaced.
+		if old != nil && old.Config != nil && old.Config.Labels[dockerContainerManagedLabel] != "" {
+			containerID, err := replaceDockerContainerBlueGreen(d, old, client)
+			if err != nil {
+				return err
+			}
+			d.SetId(containerID)
+		} else if old != nil {
+			return fmt.Errorf("Container %s exists and was not created by this provider, it cannot be replaced blue/green", d.Get("name").(string))
 		}
-		image = image + ":latest"
 	}
 
-	// The awesome, wonderful, splendiferous, sensical
-	// Docker API now lets you specify a HostConfig in
-	// CreateContainerOptions, but in my testing it still only
-	// actually applies HostConfig options set in StartContainer.
-	// How cool is that?
-	createOpts := dc.CreateContainerOptions{
-		Name: d.Get("name").(string),
-		Config: &dc.Config{
-			Image:      image,
-			Hostname:   d.Get("hostname").(string),
-			Domainname: d.Get("domainname").(string),
-		},
+	if d.Id() == "" {
+		containerID, err := createDockerContainer(d, d.Get("name").(string), client)
+		if containerID != "" {
+			d.SetId(containerID)
+		}
+		if err == nil {
+			err = startDockerContainer(containerID, client)
+		}
+		if err != nil {
+			return err
+		}
 	}
 
-	if v, ok := d.GetOk("env"); ok {
-		createOpts.Config.Env = stringSetToStringSlice(v.(*schema.Set))
+	if err := resourceDockerContainerRead(d, meta); err != nil {
+		return err
 	}
 
-	if v, ok := d.GetOk("command"); ok {
+	if v, ok := d.GetOk("export_path"); ok && d.Id() != "" {
+		return exportDockerContainer(d, client, v.(string))
+	}
+	return nil
+}
+
+func exportDockerContainer(d *schema.ResourceData, client *dc.Client, exportPath string) error {
+	sum, err := writeDockerArchive(exportPath, func(w io.Writer) error {
+		return client.ExportContainer(dc.ExportContainerOptions{
+			ID:           d.Id(),
+			OutputStream: w,
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to export container %s to %s: %s", d.Id(), exportPath, err)
+	}
+	d.Set("export_sha256", sum)
+	return nil
+}
+
+// Label set on the containers created by this provider, so that blue/green
+// replacement never takes over a container it does not manage.
+const dockerContainerManagedLabel = "terraform-provider-dockerclient.managed"
+
+// replaceDockerContainerBlueGreen creates the container next to an old one
+// with the same name, which happens when create_before_destroy is set. The
+// new container is created under a temporary name and started, and once it
+// is ready the old container is stopped and both are renamed. When fixed
+// host ports are published the old container has to release them first, so
+// it is stopped right before the new one starts. If any step fails the new
+// container is removed and the old one is put back as it was. The old
+// container is removed afterwards, when Terraform destroys it.
+func replaceDockerContainerBlueGreen(d *schema.ResourceData, old *dc.Container, client *dc.Client) (string, error) {
+	name := d.Get("name").(string)
+	tempName := name + "-next"
+	oldName := name + "-old-" + old.ID[:12]
+	timeout := time.Duration(d.Get("readiness_timeout").(int)) * time.Second
+	fixedPorts := dockerContainerHasFixedHostPorts(d)
+
+	stopped := false
+	renamed := false
+	stopOld := func() error {
+		runDockerContainerPreStop(d, old.ID, client)
+		stopTimeout := dockerContainerStopTimeout(d)
+		if stopTimeout <= 0 {
+			stopTimeout = 10
+		}
+		stopped = true
+		if err := client.StopContainer(old.ID, uint(stopTimeout)); err != nil {
+			if _, ok := err.(*dc.ContainerNotRunning); !ok {
+				return fmt.Errorf("Error stopping container %s: %s", old.ID, err)
+			}
+		}
+		return nil
+	}
+
+	log.Printf("[INFO] Creating %s to replace container %s", tempName, old.ID)
+	containerID, err := createDockerContainer(d, tempName, client)
+	if err == nil && fixedPorts {
+		err = stopOld()
+	}
+	if err == nil {
+		err = startDockerContainer(containerID, client)
+	}
+	if err == nil {
+		err = waitForDockerContainer(containerID, timeout, client)
+	}
+	if err == nil && !fixedPorts {
+		err = stopOld()
+	}
+	if err == nil {
+		err = client.RenameContainer(dc.RenameContainerOptions{ID: old.ID, Name: oldName})
+		if err != nil {
+			err = fmt.Errorf("Unable to rename container %s to %s: %s", old.ID, oldName, err)
+		}
+		renamed = err == nil
+	}
+	if err == nil {
+		err = client.RenameContainer(dc.RenameContainerOptions{ID: containerID, Name: name})
+		if err != nil {
+			err = fmt.Errorf("Unable to rename container %s to %s: %s", containerID, name, err)
+		}
+	}
+	if err == nil {
+		return containerID, nil
+	}
+
+	if containerID != "" {
+		removeOpts := dc.RemoveContainerOptions{ID: containerID, RemoveVolumes: true, Force: true}
+		if err := client.RemoveContainer(removeOpts); err != nil {
+			log.Printf("[WARN] Unable to remove container %s: %s", containerID, err)
+		}
+	}
+	if renamed {
+		if err := client.RenameContainer(dc.RenameContainerOptions{ID: old.ID, Name: name}); err != nil {
+			log.Printf("[WARN] Unable to rename container %s back to %s: %s", old.ID, name, err)
+		}
+	}
+	if stopped && old.State.Running {
+		if err := client.StartContainer(old.ID, nil); err != nil {
+			log.Printf("[WARN] Unable to restart container %s: %s", old.ID, err)
+		}
+	}
+	return "", err
+}
+
+// startDockerContainer starts a container created by createDockerContainer.
+func startDockerContainer(containerID string, client *dc.Client) error {
+	creationTime = time.Now()
+	if err := client.StartContainer(containerID, nil); err != nil {
+		return fmt.Errorf("Unable to start container: %s", err)
+	}
+	return nil
+}
+
+// createDockerContainer creates a container named name from the container
+// settings in d, ready to be started. The container ID is returned as soon
+// as the container exists, even when a later step fails, so that it is
+// tracked.
+func createDockerContainer(d ResourceConfig, name string, client *dc.Client) (string, error) {
+	var err error
+
+	var data Data
+	if err := fetchLocalImages(&data, client); err != nil {
+		return "", err
+	}
+
+	image := d.Get("image").(string)
+	if _, ok := data.DockerImages[image]; !ok {
+		if _, ok := data.DockerImages[image+":latest"]; !ok {
+			return "", fmt.Errorf("Unable to find image %s", image)
+		}
+		image = image + ":latest"
+	}
+
+	// The awesome, wonderful, splendiferous, sensical
+	// Docker API now lets you specify a HostConfig in
+	// CreateContainerOptions, but in my testing it still only
+	// actually applies HostConfig options set in StartContainer.
+	// How cool is that?
+	createOpts := dc.CreateContainerOptions{
+		Name: name,
+		Config: &dc.Config{
+			Image:      image,
+			Hostname:   d.Get("hostname").(string),
+			Domainname: d.Get("domainname").(string),
+		},
+	}
+
+	var envFile []string
+	if v, ok := d.GetOk("env_file"); ok {
+		if envFile, err = parseDockerEnvFile(v.(string)); err != nil {
+			return "", err
+		}
+	}
+	var envMap []string
+	if v, ok := d.GetOk("env_map"); ok {
+		for name, value := range v.(map[string]interface{}) {
+			envMap = append(envMap, name+"="+value.(string))
+		}
+		sort.Strings(envMap)
+	}
+	var env []string
+	if v, ok := d.GetOk("env"); ok {
+		env = stringSetToStringSlice(v.(*schema.Set))
+	}
+	if env := mergeDockerEnv(envFile, envMap, env); len(env) != 0 {
+		createOpts.Config.Env = env
+	}
+
+	if v, ok := d.GetOk("command"); ok {
 		createOpts.Config.Cmd = stringListToStringSlice(v.([]interface{}))
 		for _, v := range createOpts.Config.Cmd {
 			if v == "" {
-				return fmt.Errorf("values for command may not be empty")
+				return "", fmt.Errorf("values for command may not be empty")
 			}
 		}
 	}
@@ -636,6 +1201,34 @@ func resourceDockerContainerCreate(d *schema.ResourceData, meta interface{}) err
 		createOpts.Config.User = v.(string)
 	}
 
+	if v, ok := d.GetOk("working_dir"); ok {
+		createOpts.Config.WorkingDir = v.(string)
+	}
+
+	if v, ok := d.GetOk("shell"); ok {
+		createOpts.Config.Shell = stringListToStringSlice(v.([]interface{}))
+	}
+
+	if v, ok := d.GetOk("on_build"); ok {
+		createOpts.Config.OnBuild = stringListToStringSlice(v.([]interface{}))
+	}
+
+	createOpts.Config.Tty = d.Get("tty").(bool)
+	createOpts.Config.OpenStdin = d.Get("stdin_open").(bool)
+	createOpts.Config.StdinOnce = d.Get("stdin_once").(bool)
+
+	if v, ok := d.GetOk("mac_address"); ok {
+		createOpts.Config.MacAddress = v.(string)
+	}
+
+	if v, ok := d.GetOk("stop_signal"); ok {
+		createOpts.Config.StopSignal = v.(string)
+	}
+
+	if v, ok := d.GetOk("stop_timeout"); ok {
+		createOpts.Config.StopTimeout = v.(int)
+	}
+
 	exposedPorts := map[dc.Port]struct{}{}
 	portBindings := map[dc.Port][]dc.PortBinding{}
 
@@ -658,16 +1251,18 @@ func resourceDockerContainerCreate(d *schema.ResourceData, meta interface{}) err
 	if v, ok := d.GetOk("volumes"); ok {
 		volumes, binds, volumesFrom, err = volumeSetToDockerVolumes(v.(*schema.Set))
 		if err != nil {
-			return fmt.Errorf("Unable to parse volumes: %s", err)
+			return "", fmt.Errorf("Unable to parse volumes: %s", err)
 		}
 	}
 	if len(volumes) != 0 {
 		createOpts.Config.Volumes = volumes
 	}
 
+	createOpts.Config.Labels = map[string]string{}
 	if v, ok := d.GetOk("labels"); ok {
 		createOpts.Config.Labels = mapTypeMapValsToString(v.(map[string]interface{}))
 	}
+	createOpts.Config.Labels[dockerContainerManagedLabel] = "true"
 
 	hostConfig := &dc.HostConfig{
 		Privileged:      d.Get("privileged").(bool),
@@ -747,14 +1342,12 @@ func resourceDockerContainerCreate(d *schema.ResourceData, meta interface{}) err
 
 	var retContainer *dc.Container
 	if retContainer, err = client.CreateContainer(createOpts); err != nil {
-		return fmt.Errorf("Unable to create container: %s", err)
+		return "", fmt.Errorf("Unable to create container: %s", err)
 	}
 	if retContainer == nil {
-		return fmt.Errorf("Returned container is nil")
+		return "", fmt.Errorf("Returned container is nil")
 	}
 
-	d.SetId(retContainer.ID)
-
 	if v, ok := d.GetOk("networks"); ok {
 		var connectionOpts dc.NetworkConnectionOptions
 		if v, ok := d.GetOk("network_alias"); ok {
@@ -768,50 +1361,28 @@ func resourceDockerContainerCreate(d *schema.ResourceData, meta interface{}) err
 		for _, rawNetwork := range v.(*schema.Set).List() {
 			network := rawNetwork.(string)
 			if err := client.ConnectNetwork(network, connectionOpts); err != nil {
-				return fmt.Errorf("Unable to connect to network '%s': %s", network, err)
+				return retContainer.ID, fmt.Errorf("Unable to connect to network '%s': %s", network, err)
 			}
 		}
 	}
 
 	if v, ok := d.GetOk("upload"); ok {
-		for _, upload := range v.(*schema.Set).List() {
-			content := upload.(map[string]interface{})["content"].(string)
-			file := upload.(map[string]interface{})["file"].(string)
-
-			buf := new(bytes.Buffer)
-			tw := tar.NewWriter(buf)
-			hdr := &tar.Header{
-				Name: file,
-				Mode: 0644,
-				Size: int64(len(content)),
-			}
-			if err := tw.WriteHeader(hdr); err != nil {
-				return fmt.Errorf("Error creating tar archive: %s", err)
-			}
-			if _, err := tw.Write([]byte(content)); err != nil {
-				return fmt.Errorf("Error creating tar archive: %s", err)
-			}
-			if err := tw.Close(); err != nil {
-				return fmt.Errorf("Error creating tar archive: %s", err)
-			}
-
-			uploadOpts := dc.UploadToContainerOptions{
-				InputStream: bytes.NewReader(buf.Bytes()),
-				Path:        "/",
-			}
+		archive, err := uploadSetToTarArchive(v.(*schema.Set))
+		if err != nil {
+			return retContainer.ID, err
+		}
 
-			if err := client.UploadToContainer(retContainer.ID, uploadOpts); err != nil {
-				return fmt.Errorf("Unable to upload volume content: %s", err)
-			}
+		uploadOpts := dc.UploadToContainerOptions{
+			InputStream: bytes.NewReader(archive),
+			Path:        "/",
 		}
-	}
 
-	creationTime = time.Now()
-	if err := client.StartContainer(retContainer.ID, nil); err != nil {
-		return fmt.Errorf("Unable to start container: %s", err)
+		if err := client.UploadToContainer(retContainer.ID, uploadOpts); err != nil {
+			return retContainer.ID, fmt.Errorf("Unable to upload volume content: %s", err)
+		}
 	}
 
-	return resourceDockerContainerRead(d, meta)
+	return retContainer.ID, nil
 }
 
 func resourceDockerContainerRead(d *schema.ResourceData, meta interface{}) error {
@@ -861,8 +1432,9 @@ func resourceDockerContainerRead(d *schema.ResourceData, meta interface{}) error
 		if container.State.FinishedAt.After(creationTime) {
 			// It exited immediately, so error out so dependent containers
 			// aren't started
+			logs := fetchDockerContainerFailureLogs(container, client)
 			resourceDockerContainerDelete(d, meta)
-			return fmt.Errorf("Container %s exited after creation, error was: %s", apiContainer.ID, container.State.Error)
+			return fmt.Errorf("Container %s exited after creation, error was: %s%s", apiContainer.ID, container.State.Error, logs)
 		}
 
 		time.Sleep(sleepTime)
@@ -870,8 +1442,27 @@ func resourceDockerContainerRead(d *schema.ResourceData, meta interface{}) error
 
 	// Handle the case of the for loop above running its course
 	if !container.State.Running && d.Get("must_run").(bool) {
+		logs := fetchDockerContainerFailureLogs(container, client)
 		resourceDockerContainerDelete(d, meta)
-		return fmt.Errorf("Container %s failed to be in running state", apiContainer.ID)
+		return fmt.Errorf("Container %s failed to be in running state%s", apiContainer.ID, logs)
+	}
+
+	if container.HostConfig != nil {
+		d.Set("log_driver", container.HostConfig.LogConfig.Type)
+		d.Set("log_opts", dockerTrackedLogOpts(d, container.HostConfig.LogConfig.Config))
+	}
+
+	// Settings that cannot change without replacing the container
+	if container.Config != nil {
+		d.Set("working_dir", container.Config.WorkingDir)
+		d.Set("shell", container.Config.Shell)
+		d.Set("on_build", container.Config.OnBuild)
+		d.Set("tty", container.Config.Tty)
+		d.Set("stdin_open", container.Config.OpenStdin)
+		d.Set("stdin_once", container.Config.StdinOnce)
+		d.Set("mac_address", container.Config.MacAddress)
+		d.Set("stop_signal", container.Config.StopSignal)
+		d.Set("stop_timeout", container.Config.StopTimeout)
 	}
 
 	// Read Network Settings
@@ -886,6 +1477,18 @@ func resourceDockerContainerRead(d *schema.ResourceData, meta interface{}) error
 }
 
 func resourceDockerContainerUpdate(d *schema.ResourceData, meta interface{}) error {
+	if v, ok := d.GetOk("export_path"); ok && d.HasChange("export_path") {
+		providerConfig := meta.(*ProviderConfig)
+		resolvedConfig, _, err := providerConfig.GetResolvedConfig(d)
+		if err != nil {
+			return err
+		}
+		client, err := resolvedConfig.NewClient()
+		if err != nil {
+			return err
+		}
+		return exportDockerContainer(d, client, v.(string))
+	}
 	return nil
 }
 
@@ -900,28 +1503,112 @@ func resourceDockerContainerDelete(d *schema.ResourceData, meta interface{}) err
 		return err
 	}
 
-	// Stop the container before removing if destroy_grace_seconds is defined
-	if d.Get("destroy_grace_seconds").(int) > 0 {
-		var timeout = uint(d.Get("destroy_grace_seconds").(int))
-		if err := client.StopContainer(d.Id(), timeout); err != nil {
-			return fmt.Errorf("Error stopping container %s: %s", d.Id(), err)
+	if err := removeDockerContainer(d, d.Id(), client); err != nil {
+		return err
+	}
+
+	d.SetId("")
+	return nil
+}
+
+// dockerContainerHasFixedHostPorts reports whether ports publish a fixed
+// host port, which only one container can hold at a time.
+func dockerContainerHasFixedHostPorts(d ResourceConfig) bool {
+	if v, ok := d.GetOk("ports"); ok {
+		for _, portInt := range v.(*schema.Set).List() {
+			if portInt.(map[string]interface{})["external"].(int) != 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// removeDockerContainer runs the pre_stop hook, stops and removes a
+// container according to the container settings in d.
+func removeDockerContainer(d ResourceConfig, containerID string, client *dc.Client) error {
+	runDockerContainerPreStop(d, containerID, client)
+
+	// Stop the container before removing if destroy_grace_seconds,
+	// stop_timeout or stop_signal is defined; Docker sends the configured
+	// stop_signal. With only stop_signal the daemon default timeout applies,
+	// removing right away would kill the container instead.
+	var err error
+	if timeout := dockerContainerStopTimeout(d); timeout > 0 {
+		err = client.StopContainer(containerID, uint(timeout))
+	} else if d.Get("stop_signal").(string) != "" {
+		err = dockerStopContainer(client, containerID)
+	}
+	if err != nil {
+		if _, ok := err.(*dc.ContainerNotRunning); !ok {
+			return fmt.Errorf("Error stopping container %s: %s", containerID, err)
 		}
 	}
 
 	removeOpts := dc.RemoveContainerOptions{
-		ID:            d.Id(),
-		RemoveVolumes: true,
+		ID:            containerID,
+		RemoveVolumes: d.Get("remove_volumes").(bool),
 		Force:         true,
 	}
 
 	if err := client.RemoveContainer(removeOpts); err != nil {
-		return fmt.Errorf("Error deleting container %s: %s", d.Id(), err)
+		return fmt.Errorf("Error deleting container %s: %s", containerID, err)
 	}
-
-	d.SetId("")
 	return nil
 }
 
+// runDockerContainerPreStop gives a running container a chance to wind
+// down before it is stopped. A failing hook is not fatal, the container is
+// going away anyway.
+func runDockerContainerPreStop(d ResourceConfig, containerID string, client *dc.Client) {
+	if v, ok := d.GetOk("pre_stop"); ok {
+		if container, err := client.InspectContainer(containerID); err == nil && container.State.Running {
+			if err := execDockerContainerCommand(containerID, stringListToStringSlice(v.([]interface{})), client); err != nil {
+				log.Printf("[WARN] pre_stop command failed: %s", err)
+			}
+		}
+	}
+}
+
+// dockerContainerStopTimeout returns the seconds to wait for a container to
+// stop on destroy, or 0 when it should be killed right away.
+func dockerContainerStopTimeout(d ResourceConfig) int {
+	timeout := d.Get("destroy_grace_seconds").(int)
+	if timeout <= 0 {
+		timeout = d.Get("stop_timeout").(int)
+	}
+	return timeout
+}
+
+// waitForDockerContainer waits for a freshly started container to be
+// running and, when it has a health check, healthy.
+func waitForDockerContainer(containerID string, timeout time.Duration, client *dc.Client) error {
+	sleepTime := 500 * time.Millisecond
+	deadline := time.Now().Add(timeout)
+
+	for {
+		container, err := client.InspectContainer(containerID)
+		if err != nil {
+			return fmt.Errorf("Error inspecting container %s: %s", containerID, err)
+		}
+
+		switch {
+		case container.State.Running && container.State.Health.Status == "unhealthy":
+			return fmt.Errorf("Container %s is unhealthy%s", containerID, fetchDockerContainerFailureLogs(container, client))
+		case container.State.Running && (container.State.Health.Status == "" || container.State.Health.Status == "healthy"):
+			return nil
+		case !container.State.Running && !container.State.Restarting && container.State.FinishedAt.After(container.State.StartedAt):
+			return fmt.Errorf("Container %s exited after creation, error was: %s%s",
+				containerID, container.State.Error, fetchDockerContainerFailureLogs(container, client))
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Container %s failed to be ready within %s%s", containerID, timeout, fetchDockerContainerFailureLogs(container, client))
+		}
+		time.Sleep(sleepTime)
+	}
+}
+
 func resourceDockerContainerExists(d *schema.ResourceData, meta interface{}) (bool, error) {
 	providerConfig := meta.(*ProviderConfig)
 	resolvedConfig, deferred, err := providerConfig.GetResolvedConfig(d)
@@ -970,6 +1657,102 @@ func stringSetToStringSlice(stringSet *schema.Set) []string {
 	return ret
 }
 
+// parseDockerEnvFile reads a file with one VAR=value per line, following the
+// rules of "docker run --env-file": blank lines and lines starting with # are
+// skipped, and a bare VAR takes its value from the local environment.
+func parseDockerEnvFile(filename string) ([]string, error) {
+	fh, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open env_file: %s", err)
+	}
+	defer fh.Close()
+
+	ret := []string{}
+	scanner := bufio.NewScanner(fh)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := scanner.Text()
+		if lineNum == 1 {
+			line = strings.TrimPrefix(line, "\xEF\xBB\xBF")
+		}
+		line = strings.TrimLeftFunc(line, unicode.IsSpace)
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		data := strings.SplitN(line, "=", 2)
+		variable := strings.TrimLeftFunc(data[0], unicode.IsSpace)
+		if variable == "" {
+			return nil, fmt.Errorf("Invalid env_file %s line %d: no variable name", filename, lineNum)
+		}
+		if strings.IndexFunc(variable, unicode.IsSpace) != -1 {
+			return nil, fmt.Errorf("Invalid env_file %s line %d: variable %q contains whitespaces", filename, lineNum, variable)
+		}
+
+		if len(data) > 1 {
+			ret = append(ret, variable+"="+data[1])
+		} else if value, ok := os.LookupEnv(variable); ok {
+			ret = append(ret, variable+"="+value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Unable to read env_file: %s", err)
+	}
+	return ret, nil
+}
+
+// setDockerEnvFileDigest plans env_file_sha256 from the current content of
+// env_file. A file that does not exist yet, e.g. because it is generated
+// during the same apply, leaves it unknown until the container is created.
+func setDockerEnvFileDigest(d *schema.ResourceDiff) error {
+	if !d.NewValueKnown("env_file") {
+		return d.SetNewComputed("env_file_sha256")
+	}
+	filename := d.Get("env_file").(string)
+	if filename == "" {
+		return nil
+	}
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		return d.SetNewComputed("env_file_sha256")
+	}
+	digest, err := dockerEnvFileDigest(filename)
+	if err != nil {
+		return err
+	}
+	if digest == d.Get("env_file_sha256").(string) {
+		return nil
+	}
+	return d.SetNew("env_file_sha256", digest)
+}
+
+// dockerEnvFileDigest returns a digest of the variables read from filename.
+func dockerEnvFileDigest(filename string) (string, error) {
+	env, err := parseDockerEnvFile(filename)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(strings.Join(env, "\n")))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// mergeDockerEnv concatenates VAR=value lists, later lists overriding
+// variables already set by earlier ones.
+func mergeDockerEnv(envLists ...[]string) []string {
+	ret := []string{}
+	index := map[string]int{}
+	for _, envList := range envLists {
+		for _, env := range envList {
+			name := strings.SplitN(env, "=", 2)[0]
+			if i, ok := index[name]; ok {
+				ret[i] = env
+				continue
+			}
+			index[name] = len(ret)
+			ret = append(ret, env)
+		}
+	}
+	return ret
+}
+
 func mapTypeMapValsToString(typeMap map[string]interface{}) map[string]string {
 	mapped := make(map[string]string, len(typeMap))
 	for k, v := range typeMap {
@@ -994,6 +1777,77 @@ func fetchDockerContainer(ID string, client *dc.Client) (*dc.APIContainers, erro
 	return nil, nil
 }
 
+// Number of log lines included in the error when a container fails to start.
+const containerFailureLogLines = 20
+
+// fetchDockerContainerLogs collects the logs of a container. The stream is
+// only demultiplexed into stdout and stderr when the container has no TTY.
+func fetchDockerContainerLogs(container *dc.Container, opts dc.LogsOptions, client *dc.Client) error {
+	opts.Container = container.ID
+	if container.Config != nil && container.Config.Tty {
+		opts.RawTerminal = true
+	}
+	if err := client.Logs(opts); err != nil {
+		return fmt.Errorf("Unable to fetch logs of container %s: %s", container.ID, err)
+	}
+	return nil
+}
+
+// fetchDockerContainerFailureLogs returns the last lines of the container
+// output, formatted to be appended to an error message. Failures to fetch
+// the logs are only logged, as they must not hide the original error.
+func fetchDockerContainerFailureLogs(container *dc.Container, client *dc.Client) string {
+	buf := new(bytes.Buffer)
+	err := fetchDockerContainerLogs(container, dc.LogsOptions{
+		OutputStream: buf,
+		ErrorStream:  buf,
+		Stdout:       true,
+		Stderr:       true,
+		Tail:         strconv.Itoa(containerFailureLogLines),
+	}, client)
+	if err != nil {
+		log.Printf("[WARN] %s", err)
+		return ""
+	}
+	if buf.Len() == 0 {
+		return ""
+	}
+	return fmt.Sprintf("\nLast %d log lines:\n%s", containerFailureLogLines, buf.String())
+}
+
+// execDockerContainerCommand runs cmd inside a running container and waits
+// for it to finish, failing when it exits with a non-zero code.
+func execDockerContainerCommand(containerID string, cmd []string, client *dc.Client) error {
+	exec, err := client.CreateExec(dc.CreateExecOptions{
+		Container:    containerID,
+		Cmd:          cmd,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to create exec in container %s: %s", containerID, err)
+	}
+
+	output := new(bytes.Buffer)
+	err = client.StartExec(exec.ID, dc.StartExecOptions{
+		OutputStream: output,
+		ErrorStream:  output,
+	})
+	log.Printf("[DEBUG] Output of %v in container %s: %s", cmd, containerID, output.String())
+	if err != nil {
+		return fmt.Errorf("Unable to run %v in container %s: %s", cmd, containerID, err)
+	}
+
+	inspect, err := client.InspectExec(exec.ID)
+	if err != nil {
+		return fmt.Errorf("Unable to inspect exec in container %s: %s", containerID, err)
+	}
+	if inspect.ExitCode != 0 {
+		return fmt.Errorf("Command %v in container %s exited with code %d: %s", cmd, containerID, inspect.ExitCode, output.String())
+	}
+	return nil
+}
+
 func portSetToDockerPorts(ports *schema.Set) (map[dc.Port]struct{}, map[dc.Port][]dc.PortBinding) {
 	retExposedPorts := map[dc.Port]struct{}{}
 	retPortBindings := map[dc.Port][]dc.PortBinding{}
@@ -1073,6 +1927,297 @@ func volumeSetToDockerVolumes(volumes *schema.Set) (map[string]struct{}, []strin
 	return retVolumeMap, retHostConfigBinds, retVolumeFromContainers, nil
 }
 
+// uploadSetToTarArchive packs every upload entry into a single tar archive
+// rooted at "/", so that all files can be sent with one UploadToContainer call.
+func uploadSetToTarArchive(uploads *schema.Set) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+
+	for _, uploadInt := range uploads.List() {
+		if err := writeUploadToTar(tw, uploadInt.(map[string]interface{})); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("Error creating tar archive: %s", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func writeUploadToTar(tw *tar.Writer, upload map[string]interface{}) error {
+	file := upload["file"].(string)
+	content := upload["content"].(string)
+	contentBase64 := upload["content_base64"].(string)
+	source := upload["source"].(string)
+
+	if err := checkDockerUploadSources(upload); err != nil {
+		return err
+	}
+
+	opts := tarFileOptions{
+		UID:        upload["uid"].(int),
+		GID:        upload["gid"].(int),
+		Executable: upload["executable"].(bool),
+	}
+	if v := upload["mode"].(string); v != "" {
+		mode, err := strconv.ParseUint(v, 8, 32)
+		if err != nil {
+			return fmt.Errorf("Invalid mode %q for upload %s: %s", v, file, err)
+		}
+		opts.Mode = int64(mode)
+	}
+
+	switch {
+	case source != "":
+		return writeLocalPathToTar(tw, source, file, opts)
+	case contentBase64 != "":
+		data, err := base64.StdEncoding.DecodeString(contentBase64)
+		if err != nil {
+			return fmt.Errorf("Unable to decode content_base64 for upload %s: %s", file, err)
+		}
+		return writeFileToTar(tw, file, data, opts)
+	default:
+		return writeFileToTar(tw, file, []byte(content), opts)
+	}
+}
+
+// validateDockerUploads checks the upload blocks at plan time, so that a
+// bad block does not fail the apply after the container was created.
+func validateDockerUploads(d *schema.ResourceDiff) error {
+	uploads, ok := d.Get("upload").(*schema.Set)
+	if !ok {
+		return nil
+	}
+	for _, upload := range uploads.List() {
+		if err := checkDockerUploadSources(upload.(map[string]interface{})); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkDockerUploadSources requires exactly one of content, content_base64
+// or source in an upload block.
+func checkDockerUploadSources(upload map[string]interface{}) error {
+	sources := 0
+	for _, k := range []string{"content", "content_base64", "source"} {
+		if v, ok := upload[k].(string); ok && v != "" {
+			sources++
+		}
+	}
+	switch {
+	case sources == 0:
+		return fmt.Errorf("One of content, content_base64 or source must be set for upload %s", upload["file"])
+	case sources > 1:
+		return fmt.Errorf("Only one of content, content_base64 or source may be set for upload %s", upload["file"])
+	}
+	return nil
+}
+
+// tarFileOptions holds the ownership and permissions applied to files
+// written to a tar archive. A zero Mode keeps the default (or, for local
+// files, the mode found on disk).
+type tarFileOptions struct {
+	Mode       int64
+	UID        int
+	GID        int
+	Executable bool
+}
+
+func (o tarFileOptions) fileMode(defaultMode int64) int64 {
+	mode := defaultMode
+	if o.Mode != 0 {
+		mode = o.Mode
+	}
+	if o.Executable {
+		mode |= 0111
+	}
+	return mode
+}
+
+func writeFileToTar(tw *tar.Writer, name string, content []byte, opts tarFileOptions) error {
+	hdr := &tar.Header{
+		Name:     name,
+		Typeflag: tar.TypeReg,
+		Mode:     opts.fileMode(0644),
+		Uid:      opts.UID,
+		Gid:      opts.GID,
+		Size:     int64(len(content)),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("Error creating tar archive: %s", err)
+	}
+	if _, err := tw.Write(content); err != nil {
+		return fmt.Errorf("Error creating tar archive: %s", err)
+	}
+	return nil
+}
+
+// writeLocalPathToTar adds a local file, or a whole directory tree, to the
+// archive under name.
+func writeLocalPathToTar(tw *tar.Writer, source string, name string, opts tarFileOptions) error {
+	return filepath.Walk(source, func(localPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return fmt.Errorf("Unable to read upload source %s: %s", localPath, err)
+		}
+
+		rel, err := filepath.Rel(source, localPath)
+		if err != nil {
+			return err
+		}
+		hdr := &tar.Header{
+			Name:    path.Join(name, filepath.ToSlash(rel)),
+			Uid:     opts.UID,
+			Gid:     opts.GID,
+			ModTime: info.ModTime(),
+		}
+
+		switch {
+		case info.IsDir():
+			hdr.Typeflag = tar.TypeDir
+			hdr.Mode = int64(info.Mode().Perm())
+			if err := tw.WriteHeader(hdr); err != nil {
+				return fmt.Errorf("Error creating tar archive: %s", err)
+			}
+			return nil
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(localPath)
+			if err != nil {
+				return fmt.Errorf("Unable to read upload source %s: %s", localPath, err)
+			}
+			hdr.Typeflag = tar.TypeSymlink
+			hdr.Linkname = target
+			hdr.Mode = int64(info.Mode().Perm())
+			if err := tw.WriteHeader(hdr); err != nil {
+				return fmt.Errorf("Error creating tar archive: %s", err)
+			}
+			return nil
+		case !info.Mode().IsRegular():
+			return fmt.Errorf("Unable to upload %s: not a regular file, directory or symlink", localPath)
+		}
+
+		fh, err := os.Open(localPath)
+		if err != nil {
+			return fmt.Errorf("Unable to read upload source %s: %s", localPath, err)
+		}
+		defer fh.Close()
+
+		hdr.Typeflag = tar.TypeReg
+		hdr.Mode = opts.fileMode(int64(info.Mode().Perm()))
+		hdr.Size = info.Size()
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("Error creating tar archive: %s", err)
+		}
+		if _, err := io.Copy(tw, fh); err != nil {
+			return fmt.Errorf("Error creating tar archive: %s", err)
+		}
+		return nil
+	})
+}
+
+// setDockerUploadDigest plans upload_sha256 from the current content of the
+// upload sources. Sources that do not exist yet, e.g. because they are
+// generated during the same apply, leave it unknown until the container is
+// created.
+func setDockerUploadDigest(d *schema.ResourceDiff) error {
+	if !d.NewValueKnown("upload") {
+		return d.SetNewComputed("upload_sha256")
+	}
+	uploads, ok := d.Get("upload").(*schema.Set)
+	if !ok {
+		return nil
+	}
+	digest, err := dockerUploadsDigest(uploads)
+	if os.IsNotExist(err) {
+		return d.SetNewComputed("upload_sha256")
+	} else if err != nil {
+		return fmt.Errorf("Unable to read upload source: %s", err)
+	}
+	if digest == d.Get("upload_sha256").(string) {
+		return nil
+	}
+	return d.SetNew("upload_sha256", digest)
+}
+
+// setDockerContainerFileDigests records the digests of the local files the
+// container is created from, which are only planned when they already
+// exist.
+func setDockerContainerFileDigests(d *schema.ResourceData) error {
+	if v, ok := d.Get("upload").(*schema.Set); ok {
+		digest, err := dockerUploadsDigest(v)
+		if err != nil {
+			return fmt.Errorf("Unable to read upload source: %s", err)
+		}
+		d.Set("upload_sha256", digest)
+	}
+	if v, ok := d.GetOk("env_file"); ok {
+		digest, err := dockerEnvFileDigest(v.(string))
+		if err != nil {
+			return err
+		}
+		d.Set("env_file_sha256", digest)
+	}
+	return nil
+}
+
+// dockerUploadsDigest returns a digest of the names, modes and contents of
+// the files below every upload source, or "" when no upload has a source.
+func dockerUploadsDigest(uploads *schema.Set) (string, error) {
+	sources := []string{}
+	for _, upload := range uploads.List() {
+		if source := upload.(map[string]interface{})["source"].(string); source != "" {
+			sources = append(sources, source)
+		}
+	}
+	if len(sources) == 0 {
+		return "", nil
+	}
+	sort.Strings(sources)
+
+	hash := sha256.New()
+	for _, source := range sources {
+		fmt.Fprintf(hash, "%s\n", source)
+		if err := hashDockerUploadSource(hash, source); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// hashDockerUploadSource writes the names, modes and contents of the files
+// below source to hash.
+func hashDockerUploadSource(hash io.Writer, source string) error {
+	return filepath.Walk(source, func(localPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(source, localPath)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(hash, "%s %o\n", filepath.ToSlash(rel), info.Mode())
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(localPath)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(hash, "%s\n", target)
+		case info.Mode().IsRegular():
+			fh, err := os.Open(localPath)
+			if err != nil {
+				return err
+			}
+			defer fh.Close()
+			if _, err := io.Copy(hash, fh); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
 func fetchLocalImages(data *Data, client *dc.Client) error {
 	images, err := client.ListImages(dc.ListImagesOptions{All: false})
 	if err != nil {
@@ -0,0 +1,297 @@
+package provider
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/hashicorp/terraform/helper/schema"
+
+	dc "github.com/fsouza/go-dockerclient"
+)
+
+// resourceDockerContainerCommit snapshots a container into an image.
+func resourceDockerContainerCommit() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceDockerContainerCommitCreate,
+		Read:   resourceDockerContainerCommitRead,
+		Update: resourceDockerContainerCommitUpdate,
+		Delete: resourceDockerContainerCommitDelete,
+		Exists: resourceDockerContainerCommitExists,
+
+		Schema: map[string]*schema.Schema{
+			"host": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: true,
+			},
+
+			"machine_name": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: true,
+			},
+
+			"cert_path": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: false,
+			},
+
+			"ca_material": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: false,
+			},
+
+			"cert_material": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: false,
+			},
+
+			"key_material": {
+				Type:      schema.TypeString,
+				Optional:  true,
+				ForceNew:  false,
+				Sensitive: true,
+			},
+
+			"container_id": {
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: true,
+			},
+
+			"repository": {
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: true,
+			},
+
+			"tag": {
+				Type:     schema.TypeString,
+				Default:  "latest",
+				Optional: true,
+				ForceNew: true,
+			},
+
+			"author": {
+				Type:     schema.TypeString,
+				Optional: true,
+				Computed: true,
+				ForceNew: true,
+			},
+
+			"message": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: true,
+			},
+
+			// Dockerfile instructions applied to the image, e.g.
+			// "CMD [\"/bin/sh\"]" or "ENV DEBUG=1".
+			"changes": {
+				Type:     schema.TypeList,
+				Optional: true,
+				ForceNew: true,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+			},
+
+			// Whether the container is paused while it is committed
+			"pause": {
+				Type:     schema.TypeBool,
+				Default:  true,
+				Optional: true,
+				ForceNew: true,
+			},
+
+			"keep": {
+				Type:     schema.TypeBool,
+				Optional: true,
+			},
+
+			"created_at": {
+				Type:     schema.TypeInt,
+				Computed: true,
+			},
+
+			"docker_version": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+
+			"comment": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+
+			"os": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+
+			"architecture": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+
+			"size": {
+				Type:     schema.TypeInt,
+				Computed: true,
+			},
+
+			"virtual_size": {
+				Type:     schema.TypeInt,
+				Computed: true,
+			},
+
+			"parent": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+
+			"digests": {
+				Type:     schema.TypeList,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+				Computed: true,
+			},
+
+			"all_tags": {
+				Type:     schema.TypeList,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+				Computed: true,
+			},
+
+			"labels": {
+				Type:     schema.TypeMap,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+				Computed: true,
+			},
+		},
+	}
+}
+
+func resourceDockerContainerCommitCreate(d *schema.ResourceData, meta interface{}) error {
+	providerConfig := meta.(*ProviderConfig)
+	resolvedConfig, _, err := providerConfig.GetResolvedConfig(d)
+	if err != nil {
+		return err
+	}
+	client, err := resolvedConfig.NewClient()
+	if err != nil {
+		return err
+	}
+
+	commitOpts := dc.CommitContainerOptions{
+		Container:  d.Get("container_id").(string),
+		Repository: d.Get("repository").(string),
+		Tag:        d.Get("tag").(string),
+		Author:     d.Get("author").(string),
+		Message:    d.Get("message").(string),
+	}
+	if v, ok := d.GetOk("changes"); ok {
+		commitOpts.Changes = stringListToStringSlice(v.([]interface{}))
+	}
+
+	imageID, err := dockerCommitContainer(client, commitOpts, d.Get("pause").(bool))
+	if err != nil {
+		return fmt.Errorf("Unable to commit container %s: %s", commitOpts.Container, err)
+	}
+
+	d.SetId(imageID)
+	return resourceDockerContainerCommitRead(d, meta)
+}
+
+func resourceDockerContainerCommitRead(d *schema.ResourceData, meta interface{}) error {
+	providerConfig := meta.(*ProviderConfig)
+	resolvedConfig, _, err := providerConfig.GetResolvedConfig(d)
+	if err != nil {
+		return err
+	}
+	client, err := resolvedConfig.NewClient()
+	if err != nil {
+		return err
+	}
+
+	image, err := client.InspectImage(d.Id())
+	if err == dc.ErrNoSuchImage {
+		d.SetId("")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to inspect image %s: %s", d.Id(), err)
+	}
+	readDockerImageAttributes(d, image)
+	return nil
+}
+
+func resourceDockerContainerCommitUpdate(d *schema.ResourceData, meta interface{}) error {
+	return nil
+}
+
+func resourceDockerContainerCommitDelete(d *schema.ResourceData, meta interface{}) error {
+	providerConfig := meta.(*ProviderConfig)
+	resolvedConfig, _, err := providerConfig.GetResolvedConfig(d)
+	if err != nil {
+		return err
+	}
+	client, err := resolvedConfig.NewClient()
+	if err != nil {
+		return err
+	}
+
+	if d.Get("keep").(bool) {
+		d.SetId("")
+		return nil
+	}
+
+	// Leave the tag alone if it has been moved to another image since
+	imageName := d.Get("repository").(string) + ":" + d.Get("tag").(string)
+	image, err := client.InspectImage(imageName)
+	if err == dc.ErrNoSuchImage {
+		d.SetId("")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to inspect image %s: %s", imageName, err)
+	}
+	if image.ID != d.Id() {
+		log.Printf("[WARN] Tag %s now points to image %s, not removing it", imageName, image.ID)
+		d.SetId("")
+		return nil
+	}
+
+	// Only the tag created by the commit is removed, the image goes away
+	// with it unless something else still references it.
+	if err := client.RemoveImage(imageName); err != nil && err != dc.ErrNoSuchImage {
+		return fmt.Errorf("Error deleting image %s: %s", imageName, err)
+	}
+
+	d.SetId("")
+	return nil
+}
+
+func resourceDockerContainerCommitExists(d *schema.ResourceData, meta interface{}) (bool, error) {
+	providerConfig := meta.(*ProviderConfig)
+	resolvedConfig, deferred, err := providerConfig.GetResolvedConfig(d)
+	if deferred {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	client, err := resolvedConfig.NewClient()
+	if err != nil {
+		return false, err
+	}
+
+	_, err = client.InspectImage(d.Id())
+	switch err {
+	case nil:
+		return true, nil
+	case dc.ErrNoSuchImage:
+		return false, nil
+	default:
+		return false, err
+	}
+}
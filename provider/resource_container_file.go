@@ -0,0 +1,402 @@
+package provider
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/terraform/helper/schema"
+
+	dc "github.com/fsouza/go-dockerclient"
+)
+
+// resourceDockerContainerFile manages a single file inside a running
+// container. Unlike the upload block of dockerclient_container, changes are
+// applied in place, which suits configuration files the application
+// reloads on its own or after reload_signal / reload_command.
+func resourceDockerContainerFile() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceDockerContainerFileCreate,
+		Read:   resourceDockerContainerFileRead,
+		Update: resourceDockerContainerFileUpdate,
+		Delete: resourceDockerContainerFileDelete,
+		Exists: resourceDockerContainerFileExists,
+
+		Schema: map[string]*schema.Schema{
+			"host": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: true,
+			},
+
+			"machine_name": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: true,
+			},
+
+			"cert_path": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: false,
+			},
+
+			"ca_material": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: false,
+			},
+
+			"cert_material": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: false,
+			},
+
+			"key_material": {
+				Type:      schema.TypeString,
+				Optional:  true,
+				ForceNew:  false,
+				Sensitive: true,
+			},
+
+			"container_id": {
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: true,
+			},
+
+			"file": {
+				Type:         schema.TypeString,
+				Required:     true,
+				ForceNew:     true,
+				ValidateFunc: validateDockerContainerPath,
+			},
+
+			"content": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				ConflictsWith: []string{"content_base64"},
+			},
+
+			"content_base64": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				ConflictsWith: []string{"content"},
+			},
+
+			"mode": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ValidateFunc: validateDockerFileMode,
+			},
+
+			"uid": {
+				Type:     schema.TypeInt,
+				Optional: true,
+			},
+
+			"gid": {
+				Type:     schema.TypeInt,
+				Optional: true,
+			},
+
+			"executable": {
+				Type:     schema.TypeBool,
+				Optional: true,
+			},
+
+			// Signal sent to the container after the file is written,
+			// e.g. "SIGHUP".
+			"reload_signal": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ValidateFunc: validateDockerSignal,
+			},
+
+			// Command run inside the container after the file is written.
+			// A non-zero exit code fails the apply.
+			"reload_command": {
+				Type:     schema.TypeList,
+				Optional: true,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+			},
+		},
+	}
+}
+
+func resourceDockerContainerFileCreate(d *schema.ResourceData, meta interface{}) error {
+	providerConfig := meta.(*ProviderConfig)
+	resolvedConfig, _, err := providerConfig.GetResolvedConfig(d)
+	if err != nil {
+		return err
+	}
+	client, err := resolvedConfig.NewClient()
+	if err != nil {
+		return err
+	}
+
+	containerID := d.Get("container_id").(string)
+	file := d.Get("file").(string)
+
+	if err := writeDockerContainerFile(d, client); err != nil {
+		return err
+	}
+
+	d.SetId(containerID + ":" + file)
+
+	if err := reloadDockerContainerFile(d, client); err != nil {
+		return err
+	}
+
+	return resourceDockerContainerFileRead(d, meta)
+}
+
+func resourceDockerContainerFileRead(d *schema.ResourceData, meta interface{}) error {
+	providerConfig := meta.(*ProviderConfig)
+	resolvedConfig, _, err := providerConfig.GetResolvedConfig(d)
+	if err != nil {
+		return err
+	}
+	client, err := resolvedConfig.NewClient()
+	if err != nil {
+		return err
+	}
+
+	hdr, content, err := fetchDockerContainerFile(d.Get("container_id").(string), d.Get("file").(string), client)
+	if err != nil {
+		return err
+	}
+	if hdr == nil {
+		// Either the container or the file is gone
+		d.SetId("")
+		return nil
+	}
+
+	if _, ok := d.GetOk("content_base64"); ok {
+		d.Set("content_base64", base64.StdEncoding.EncodeToString(content))
+	} else {
+		d.Set("content", string(content))
+	}
+
+	// Only report a mode when it differs from what the configuration would
+	// produce, so that an unset mode does not show up as a permanent diff.
+	opts, err := containerFileTarOptions(d)
+	if err != nil {
+		return err
+	}
+	if actual := hdr.Mode & 07777; actual != opts.fileMode(0644) {
+		d.Set("mode", fmt.Sprintf("%04o", actual))
+	}
+	d.Set("uid", hdr.Uid)
+	d.Set("gid", hdr.Gid)
+
+	return nil
+}
+
+func resourceDockerContainerFileUpdate(d *schema.ResourceData, meta interface{}) error {
+	providerConfig := meta.(*ProviderConfig)
+	resolvedConfig, _, err := providerConfig.GetResolvedConfig(d)
+	if err != nil {
+		return err
+	}
+	client, err := resolvedConfig.NewClient()
+	if err != nil {
+		return err
+	}
+
+	if d.HasChange("content") || d.HasChange("content_base64") || d.HasChange("mode") ||
+		d.HasChange("uid") || d.HasChange("gid") || d.HasChange("executable") {
+		if err := writeDockerContainerFile(d, client); err != nil {
+			return err
+		}
+		if err := reloadDockerContainerFile(d, client); err != nil {
+			return err
+		}
+	}
+
+	return resourceDockerContainerFileRead(d, meta)
+}
+
+// The file is left in place on destroy: there is no API to remove a file
+// from a container, and the container itself owns its filesystem.
+func resourceDockerContainerFileDelete(d *schema.ResourceData, meta interface{}) error {
+	d.SetId("")
+	return nil
+}
+
+func resourceDockerContainerFileExists(d *schema.ResourceData, meta interface{}) (bool, error) {
+	providerConfig := meta.(*ProviderConfig)
+	resolvedConfig, deferred, err := providerConfig.GetResolvedConfig(d)
+	if deferred {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	client, err := resolvedConfig.NewClient()
+	if err != nil {
+		return false, err
+	}
+
+	hdr, _, err := fetchDockerContainerFile(d.Get("container_id").(string), d.Get("file").(string), client)
+	if err != nil {
+		return false, err
+	}
+	return hdr != nil, nil
+}
+
+func containerFileTarOptions(d *schema.ResourceData) (tarFileOptions, error) {
+	opts := tarFileOptions{
+		UID:        d.Get("uid").(int),
+		GID:        d.Get("gid").(int),
+		Executable: d.Get("executable").(bool),
+	}
+	if v := d.Get("mode").(string); v != "" {
+		mode, err := strconv.ParseUint(v, 8, 32)
+		if err != nil {
+			return opts, fmt.Errorf("Invalid mode %q: %s", v, err)
+		}
+		opts.Mode = int64(mode)
+	}
+	return opts, nil
+}
+
+func writeDockerContainerFile(d *schema.ResourceData, client *dc.Client) error {
+	containerID := d.Get("container_id").(string)
+	file := d.Get("file").(string)
+
+	content := []byte(d.Get("content").(string))
+	if v, ok := d.GetOk("content_base64"); ok {
+		var err error
+		if content, err = base64.StdEncoding.DecodeString(v.(string)); err != nil {
+			return fmt.Errorf("Unable to decode content_base64: %s", err)
+		}
+	}
+
+	opts, err := containerFileTarOptions(d)
+	if err != nil {
+		return err
+	}
+
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	if err := writeFileToTar(tw, file, content, opts); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("Error creating tar archive: %s", err)
+	}
+
+	uploadOpts := dc.UploadToContainerOptions{
+		InputStream: bytes.NewReader(buf.Bytes()),
+		Path:        "/",
+	}
+	if err := client.UploadToContainer(containerID, uploadOpts); err != nil {
+		return fmt.Errorf("Unable to upload %s to container %s: %s", file, containerID, err)
+	}
+	return nil
+}
+
+func reloadDockerContainerFile(d *schema.ResourceData, client *dc.Client) error {
+	containerID := d.Get("container_id").(string)
+
+	if v, ok := d.GetOk("reload_signal"); ok {
+		signal, err := parseDockerSignal(v.(string))
+		if err != nil {
+			return err
+		}
+		log.Printf("[DEBUG] Sending %s to container %s", v.(string), containerID)
+		if err := client.KillContainer(dc.KillContainerOptions{ID: containerID, Signal: signal}); err != nil {
+			return fmt.Errorf("Unable to signal container %s: %s", containerID, err)
+		}
+	}
+
+	if v, ok := d.GetOk("reload_command"); ok {
+		if err := execDockerContainerCommand(containerID, stringListToStringSlice(v.([]interface{})), client); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// fetchDockerContainerFile downloads a single file from a container. A nil
+// header is returned when either the container or the file does not exist.
+func fetchDockerContainerFile(containerID string, file string, client *dc.Client) (*tar.Header, []byte, error) {
+	apiContainer, err := fetchDockerContainer(containerID, client)
+	if err != nil {
+		return nil, nil, err
+	}
+	if apiContainer == nil {
+		return nil, nil, nil
+	}
+
+	buf := new(bytes.Buffer)
+	err = client.DownloadFromContainer(apiContainer.ID, dc.DownloadFromContainerOptions{
+		Path:         file,
+		OutputStream: buf,
+	})
+	if err != nil {
+		if e, ok := err.(*dc.Error); ok && e.Status == http.StatusNotFound {
+			return nil, nil, nil
+		}
+		return nil, nil, fmt.Errorf("Unable to download %s from container %s: %s", file, containerID, err)
+	}
+
+	tr := tar.NewReader(buf)
+	hdr, err := tr.Next()
+	if err == io.EOF {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error reading tar archive: %s", err)
+	}
+	if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+		return nil, nil, fmt.Errorf("%s in container %s is not a regular file", file, containerID)
+	}
+	content, err := ioutil.ReadAll(tr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error reading tar archive: %s", err)
+	}
+	return hdr, content, nil
+}
+
+var dockerSignals = map[string]dc.Signal{
+	"HUP":   dc.SIGHUP,
+	"INT":   dc.SIGINT,
+	"QUIT":  dc.SIGQUIT,
+	"KILL":  dc.SIGKILL,
+	"USR1":  dc.SIGUSR1,
+	"USR2":  dc.SIGUSR2,
+	"TERM":  dc.SIGTERM,
+	"CONT":  dc.SIGCONT,
+	"STOP":  dc.SIGSTOP,
+	"WINCH": dc.SIGWINCH,
+}
+
+// parseDockerSignal accepts a signal number or a name with or without
+// the SIG prefix.
+func parseDockerSignal(value string) (dc.Signal, error) {
+	if n, err := strconv.Atoi(value); err == nil && n > 0 {
+		return dc.Signal(n), nil
+	}
+	if signal, ok := dockerSignals[strings.TrimPrefix(strings.ToUpper(value), "SIG")]; ok {
+		return signal, nil
+	}
+	return 0, fmt.Errorf("Unknown signal %q", value)
+}
+
+func validateDockerSignal(v interface{}, k string) (ws []string, es []error) {
+	if _, err := parseDockerSignal(v.(string)); err != nil {
+		es = append(es, fmt.Errorf("%q: %s", k, err))
+	}
+	return
+}
@@ -0,0 +1,515 @@
+package provider
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform/helper/schema"
+
+	dc "github.com/fsouza/go-dockerclient"
+)
+
+// Container settings that only matter when a replica is destroyed, or that
+// select the Docker host. Changing them never rolls the replicas.
+var dockerContainerGroupNonSpecKeys = map[string]bool{
+	"host":                  true,
+	"machine_name":          true,
+	"cert_path":             true,
+	"ca_material":           true,
+	"cert_material":         true,
+	"key_material":          true,
+	"must_run":              true,
+	"destroy_grace_seconds": true,
+	"pre_stop":              true,
+	"remove_volumes":        true,
+	"blue_green":            true,
+	"readiness_timeout":     true,
+	"export_path":           true,
+}
+
+// resourceDockerContainerGroup manages a number of identical containers
+// built from the same settings as dockerclient_container. Scaling adds or
+// removes replicas in place, and changing the container settings replaces
+// the replicas a few at a time instead of replacing the whole group.
+func resourceDockerContainerGroup() *schema.Resource {
+	s := resourceDockerContainer().Schema
+
+	delete(s, "name")
+	for _, k := range []string{"ip_address", "ip_prefix_length", "gateway", "bridge", "blue_green", "export_path", "export_sha256"} {
+		delete(s, k)
+	}
+	for k, v := range s {
+		if k != "host" && k != "machine_name" {
+			clearSchemaForceNew(v)
+		}
+	}
+
+	// Name of each replica, where %d is replaced by the replica index.
+	s["name_template"] = &schema.Schema{
+		Type:     schema.TypeString,
+		Required: true,
+		ForceNew: true,
+		ValidateFunc: func(v interface{}, k string) (ws []string, es []error) {
+			if !strings.Contains(v.(string), "%d") {
+				es = append(es, fmt.Errorf("%q must contain %%d, which is replaced by the replica index", k))
+			}
+			return
+		},
+	}
+
+	s["replicas"] = &schema.Schema{
+		Type:     schema.TypeInt,
+		Required: true,
+		ValidateFunc: func(v interface{}, k string) (ws []string, es []error) {
+			if v.(int) < 1 {
+				es = append(es, fmt.Errorf("%q must be greater than or equal to 1", k))
+			}
+			return
+		},
+	}
+
+	// Number of replicas replaced at the same time while rolling out new
+	// settings. Each replacement is started and ready before the old
+	// replica is stopped, except when fixed host ports are published: the
+	// old replicas then have to release them first, so this many replicas
+	// may be down at the same time.
+	s["max_unavailable"] = &schema.Schema{
+		Type:     schema.TypeInt,
+		Optional: true,
+		Default:  1,
+		ValidateFunc: func(v interface{}, k string) (ws []string, es []error) {
+			if v.(int) < 1 {
+				es = append(es, fmt.Errorf("%q must be greater than or equal to 1", k))
+			}
+			return
+		},
+	}
+
+	s["names"] = &schema.Schema{
+		Type:     schema.TypeList,
+		Computed: true,
+		Elem:     &schema.Schema{Type: schema.TypeString},
+	}
+
+	s["container_ids"] = &schema.Schema{
+		Type:     schema.TypeList,
+		Computed: true,
+		Elem:     &schema.Schema{Type: schema.TypeString},
+	}
+
+	return &schema.Resource{
+		Create: resourceDockerContainerGroupCreate,
+		Read:   resourceDockerContainerGroupRead,
+		Update: resourceDockerContainerGroupUpdate,
+		Delete: resourceDockerContainerGroupDelete,
+		Exists: resourceDockerContainerGroupExists,
+
+		CustomizeDiff: func(d *schema.ResourceDiff, meta interface{}) error {
+			if err := validateDockerUploads(d); err != nil {
+				return err
+			}
+			if err := setDockerUploadDigest(d); err != nil {
+				return err
+			}
+			if err := setDockerEnvFileDigest(d); err != nil {
+				return err
+			}
+			return validateDockerLogConfig(d, meta)
+		},
+
+		Schema: s,
+	}
+}
+
+func clearSchemaForceNew(s *schema.Schema) {
+	s.ForceNew = false
+	if r, ok := s.Elem.(*schema.Resource); ok {
+		for _, v := range r.Schema {
+			clearSchemaForceNew(v)
+		}
+	}
+}
+
+func dockerContainerGroupReplicaName(d ResourceConfig, index int) string {
+	return strings.Replace(d.Get("name_template").(string), "%d", strconv.Itoa(index), -1)
+}
+
+// dockerContainerGroupReplicas returns the replicas recorded in the state,
+// as a map of names to container IDs.
+func dockerContainerGroupReplicas(d *schema.ResourceData) map[string]string {
+	replicas := map[string]string{}
+	names := stringListToStringSlice(d.Get("names").([]interface{}))
+	ids := stringListToStringSlice(d.Get("container_ids").([]interface{}))
+	for i := range names {
+		if i < len(ids) {
+			replicas[names[i]] = ids[i]
+		}
+	}
+	return replicas
+}
+
+// sortedDockerContainerGroupNames returns the replica names in index order.
+// All names come from the same template, so a shorter name has a lower index.
+func sortedDockerContainerGroupNames(replicas map[string]string) []string {
+	names := []string{}
+	for name := range replicas {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if len(names[i]) != len(names[j]) {
+			return len(names[i]) < len(names[j])
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+// setDockerContainerGroupReplicas records the replicas in index order.
+func setDockerContainerGroupReplicas(d *schema.ResourceData, replicas map[string]string) {
+	names := sortedDockerContainerGroupNames(replicas)
+	ids := []string{}
+	for _, name := range names {
+		ids = append(ids, replicas[name])
+	}
+	d.Set("names", names)
+	d.Set("container_ids", ids)
+	d.SetPartial("names")
+	d.SetPartial("container_ids")
+}
+
+func dockerContainerGroupSpecChanged(d *schema.ResourceData) bool {
+	for k := range resourceDockerContainer().Schema {
+		if !dockerContainerGroupNonSpecKeys[k] && d.HasChange(k) {
+			return true
+		}
+	}
+	return false
+}
+
+func resourceDockerContainerGroupCreate(d *schema.ResourceData, meta interface{}) error {
+	d.SetId(d.Get("name_template").(string))
+	d.Set("names", []string{})
+	d.Set("container_ids", []string{})
+
+	return resourceDockerContainerGroupUpdate(d, meta)
+}
+
+func resourceDockerContainerGroupRead(d *schema.ResourceData, meta interface{}) error {
+	providerConfig := meta.(*ProviderConfig)
+	resolvedConfig, _, err := providerConfig.GetResolvedConfig(d)
+	if err != nil {
+		return err
+	}
+	client, err := resolvedConfig.NewClient()
+	if err != nil {
+		return err
+	}
+
+	apiContainers, err := client.ListContainers(dc.ListContainersOptions{All: true})
+	if err != nil {
+		return fmt.Errorf("Error fetching container information from Docker: %s\n", err)
+	}
+	states := map[string]string{}
+	for _, apiContainer := range apiContainers {
+		states[apiContainer.ID] = apiContainer.State
+	}
+
+	// Replicas that are gone are dropped. Replicas stopped while they must
+	// run are kept, so that the next apply removes them, but are not
+	// counted, so that it recreates them.
+	replicas := dockerContainerGroupReplicas(d)
+	live := 0
+	for name, id := range replicas {
+		state, ok := states[id]
+		if !ok {
+			log.Printf("[DEBUG] Replica %s (%s) no longer exists", name, id)
+			delete(replicas, name)
+			continue
+		}
+		if dockerContainerGroupReplicaLive(d, state) {
+			live++
+		} else {
+			log.Printf("[DEBUG] Replica %s (%s) is %s", name, id, state)
+		}
+	}
+
+	if len(replicas) == 0 {
+		d.SetId("")
+		return nil
+	}
+
+	setDockerContainerGroupReplicas(d, replicas)
+	d.Set("replicas", live)
+	return nil
+}
+
+// dockerContainerGroupReplicaLive reports whether a replica in the given
+// state counts towards replicas.
+func dockerContainerGroupReplicaLive(d *schema.ResourceData, state string) bool {
+	return state == "running" || !d.Get("must_run").(bool)
+}
+
+func resourceDockerContainerGroupUpdate(d *schema.ResourceData, meta interface{}) error {
+	providerConfig := meta.(*ProviderConfig)
+	resolvedConfig, _, err := providerConfig.GetResolvedConfig(d)
+	if err != nil {
+		return err
+	}
+	client, err := resolvedConfig.NewClient()
+	if err != nil {
+		return err
+	}
+
+	if err := setDockerContainerFileDigests(d); err != nil {
+		return err
+	}
+
+	// Only the replicas that were actually changed are saved if anything
+	// below fails.
+	d.Partial(true)
+
+	replicas := dockerContainerGroupReplicas(d)
+	count := d.Get("replicas").(int)
+
+	// Replicas that stopped are removed, and recreated below
+	if len(replicas) != 0 {
+		apiContainers, err := client.ListContainers(dc.ListContainersOptions{All: true})
+		if err != nil {
+			return fmt.Errorf("Error fetching container information from Docker: %s\n", err)
+		}
+		states := map[string]string{}
+		for _, apiContainer := range apiContainers {
+			states[apiContainer.ID] = apiContainer.State
+		}
+		for name, id := range replicas {
+			state, ok := states[id]
+			if ok && dockerContainerGroupReplicaLive(d, state) {
+				continue
+			}
+			if ok {
+				log.Printf("[INFO] Removing %s replica %s", state, name)
+				if err := removeDockerContainer(d, id, client); err != nil {
+					return err
+				}
+			}
+			delete(replicas, name)
+			setDockerContainerGroupReplicas(d, replicas)
+		}
+	}
+
+	// Scale down first, so that fewer replicas need to be rolled
+	wanted := map[string]bool{}
+	for i := 0; i < count; i++ {
+		wanted[dockerContainerGroupReplicaName(d, i)] = true
+	}
+	for name, id := range replicas {
+		if wanted[name] {
+			continue
+		}
+		log.Printf("[INFO] Removing replica %s", name)
+		if err := removeDockerContainer(d, id, client); err != nil {
+			return err
+		}
+		delete(replicas, name)
+		setDockerContainerGroupReplicas(d, replicas)
+	}
+
+	if !d.IsNewResource() && dockerContainerGroupSpecChanged(d) {
+		if err := rollDockerContainerGroup(d, replicas, client); err != nil {
+			return err
+		}
+	}
+
+	for i := 0; i < count; i++ {
+		name := dockerContainerGroupReplicaName(d, i)
+		if _, ok := replicas[name]; ok {
+			continue
+		}
+		log.Printf("[INFO] Creating replica %s", name)
+		id, err := createDockerContainer(d, name, client)
+		if id != "" {
+			replicas[name] = id
+			setDockerContainerGroupReplicas(d, replicas)
+		}
+		if err == nil {
+			err = startDockerContainer(id, client)
+		}
+		if err != nil {
+			return err
+		}
+		if err := waitForDockerContainer(id, dockerContainerGroupReadinessTimeout(d), client); err != nil {
+			return err
+		}
+	}
+
+	d.Partial(false)
+	return resourceDockerContainerGroupRead(d, meta)
+}
+
+func dockerContainerGroupReadinessTimeout(d *schema.ResourceData) time.Duration {
+	return time.Duration(d.Get("readiness_timeout").(int)) * time.Second
+}
+
+// dockerContainerGroupReplacement tracks a replica while it is replaced.
+type dockerContainerGroupReplacement struct {
+	name    string
+	oldID   string
+	newID   string
+	stopped bool
+}
+
+// rollDockerContainerGroup replaces the replicas with containers built from
+// the new settings, max_unavailable replicas at a time. The old container
+// is renamed out of the way first, its replacement is started, and the old
+// container is only stopped and removed once the replacement is ready. A
+// replacement that fails to become ready is discarded and the old
+// container is put back. With fixed host ports the old container is
+// stopped before its replacement starts instead.
+func rollDockerContainerGroup(d *schema.ResourceData, replicas map[string]string, client *dc.Client) error {
+	batchSize := d.Get("max_unavailable").(int)
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	stopFirst := dockerContainerHasFixedHostPorts(d)
+
+	names := sortedDockerContainerGroupNames(replicas)
+	for start := 0; start < len(names); start += batchSize {
+		end := start + batchSize
+		if end > len(names) {
+			end = len(names)
+		}
+
+		batch := []*dockerContainerGroupReplacement{}
+		for _, name := range names[start:end] {
+			r := &dockerContainerGroupReplacement{name: name, oldID: replicas[name]}
+			batch = append(batch, r)
+
+			log.Printf("[INFO] Replacing replica %s", name)
+			err := client.RenameContainer(dc.RenameContainerOptions{ID: r.oldID, Name: name + "-old"})
+			if err != nil {
+				err = fmt.Errorf("Unable to rename container %s: %s", r.oldID, err)
+				return rollbackDockerContainerGroup(batch, client, err)
+			}
+
+			// Stopping first releases the host ports of the old replica
+			if stopFirst {
+				runDockerContainerPreStop(d, r.oldID, client)
+				timeout := dockerContainerStopTimeout(d)
+				if timeout <= 0 {
+					timeout = 10
+				}
+				if err := client.StopContainer(r.oldID, uint(timeout)); err != nil {
+					if _, ok := err.(*dc.ContainerNotRunning); !ok {
+						err = fmt.Errorf("Error stopping container %s: %s", r.oldID, err)
+						return rollbackDockerContainerGroup(batch, client, err)
+					}
+				}
+				r.stopped = true
+			}
+		}
+
+		for _, r := range batch {
+			var err error
+			r.newID, err = createDockerContainer(d, r.name, client)
+			if err == nil {
+				err = startDockerContainer(r.newID, client)
+			}
+			if err == nil {
+				err = waitForDockerContainer(r.newID, dockerContainerGroupReadinessTimeout(d), client)
+			}
+			if err != nil {
+				return rollbackDockerContainerGroup(batch, client, err)
+			}
+		}
+
+		for _, r := range batch {
+			if err := removeDockerContainer(d, r.oldID, client); err != nil {
+				return err
+			}
+			replicas[r.name] = r.newID
+			setDockerContainerGroupReplicas(d, replicas)
+		}
+	}
+
+	return nil
+}
+
+// rollbackDockerContainerGroup discards the replacements of a batch and
+// restores the old replicas, returning the error that caused the rollback.
+func rollbackDockerContainerGroup(batch []*dockerContainerGroupReplacement, client *dc.Client, cause error) error {
+	for _, r := range batch {
+		if r.newID != "" {
+			err := client.RemoveContainer(dc.RemoveContainerOptions{ID: r.newID, RemoveVolumes: true, Force: true})
+			if err != nil {
+				log.Printf("[WARN] Unable to remove replacement container %s: %s", r.newID, err)
+				continue
+			}
+		}
+		if err := client.RenameContainer(dc.RenameContainerOptions{ID: r.oldID, Name: r.name}); err != nil {
+			log.Printf("[WARN] Unable to rename container %s back to %s: %s", r.oldID, r.name, err)
+		}
+		if r.stopped {
+			if err := client.StartContainer(r.oldID, nil); err != nil {
+				log.Printf("[WARN] Unable to restart container %s: %s", r.oldID, err)
+			}
+		}
+	}
+	return fmt.Errorf("Rolling update of the group failed, the batch was rolled back: %s", cause)
+}
+
+func resourceDockerContainerGroupDelete(d *schema.ResourceData, meta interface{}) error {
+	providerConfig := meta.(*ProviderConfig)
+	resolvedConfig, _, err := providerConfig.GetResolvedConfig(d)
+	if err != nil {
+		return err
+	}
+	client, err := resolvedConfig.NewClient()
+	if err != nil {
+		return err
+	}
+
+	d.Partial(true)
+
+	replicas := dockerContainerGroupReplicas(d)
+	for name, id := range replicas {
+		if err := removeDockerContainer(d, id, client); err != nil {
+			return err
+		}
+		delete(replicas, name)
+		setDockerContainerGroupReplicas(d, replicas)
+	}
+
+	d.Partial(false)
+	d.SetId("")
+	return nil
+}
+
+func resourceDockerContainerGroupExists(d *schema.ResourceData, meta interface{}) (bool, error) {
+	providerConfig := meta.(*ProviderConfig)
+	resolvedConfig, deferred, err := providerConfig.GetResolvedConfig(d)
+	if deferred {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	client, err := resolvedConfig.NewClient()
+	if err != nil {
+		return false, err
+	}
+
+	for _, id := range dockerContainerGroupReplicas(d) {
+		apiContainer, err := fetchDockerContainer(id, client)
+		if err != nil {
+			return false, err
+		}
+		if apiContainer != nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,61 @@
+package provider
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform/config"
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+// Removing log_opts, or only some of its keys, from the configuration
+// replaces the container, unchanged options do not.
+func TestResourceDockerContainerCustomizeDiffLogOpts(t *testing.T) {
+	cases := []struct {
+		name    string
+		opts    map[string]interface{}
+		replace bool
+	}{
+		{"unchanged", map[string]interface{}{"max-size": "10m", "max-file": "3"}, false},
+		{"key removed", map[string]interface{}{"max-size": "10m"}, true},
+		{"block removed", nil, true},
+	}
+
+	for _, c := range cases {
+		settings := map[string]interface{}{
+			"name":  "app",
+			"image": "sha256:image",
+		}
+		d := schema.TestResourceDataRaw(t, resourceDockerContainer().Schema, settings)
+		d.SetId("container")
+		d.Set("log_driver", "json-file")
+		d.Set("log_opts", map[string]interface{}{"max-size": "10m", "max-file": "3"})
+		state := d.State()
+
+		if c.opts != nil {
+			settings["log_opts"] = c.opts
+		}
+		raw, err := config.NewRawConfig(settings)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Without a host the log driver is only checked at apply time
+		diff, err := resourceDockerContainer().Diff(state, terraform.NewResourceConfig(raw), &ProviderConfig{})
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		replace := false
+		if diff != nil {
+			for k, attr := range diff.Attributes {
+				if strings.HasPrefix(k, "log_opts.") && attr.RequiresNew {
+					replace = true
+				}
+			}
+		}
+		if replace != c.replace {
+			t.Errorf("%s: expected the container to be replaced to be %v, got %v", c.name, c.replace, replace)
+		}
+	}
+}
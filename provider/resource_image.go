@@ -1,9 +1,24 @@
 package provider
 
 import (
+	"archive/tar"
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
 	"time"
 
@@ -19,6 +34,8 @@ func resourceDockerImage() *schema.Resource {
 		Delete: resourceDockerImageDelete,
 		Exists: resourceDockerImageExists,
 
+		CustomizeDiff: resourceDockerImageCustomizeDiff,
+
 		Schema: map[string]*schema.Schema{
 			"host": {
 				Type:     schema.TypeString,
@@ -80,28 +97,79 @@ func resourceDockerImage() *schema.Resource {
 				Type:          schema.TypeString,
 				Optional:      true,
 				ForceNew:      true,
-				ConflictsWith: []string{"build_remote_path", "load_path", "pull"},
+				ConflictsWith: []string{"build_remote_path", "load_path", "import_path", "pull"},
 			},
 
 			"build_remote_path": {
 				Type:          schema.TypeString,
 				Optional:      true,
 				ForceNew:      true,
-				ConflictsWith: []string{"build_local_path", "load_path", "pull"},
+				ConflictsWith: []string{"build_local_path", "load_path", "import_path", "pull"},
 			},
 
 			"load_path": {
 				Type:          schema.TypeString,
 				Optional:      true,
 				ForceNew:      true,
-				ConflictsWith: []string{"build_local_path", "build_remote_path", "pull"},
+				ConflictsWith: []string{"build_local_path", "build_remote_path", "import_path", "pull"},
+			},
+
+			// Tag the image loaded from load_path as name:tag when the
+			// archive does not already carry that tag.
+			"retag_loaded": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				ForceNew: true,
+			},
+
+			// Repository tags found in the load_path archive.
+			"loaded_tags": {
+				Type:     schema.TypeList,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+				Computed: true,
+			},
+
+			// Root filesystem tarball imported as name:tag, e.g. the
+			// output of debootstrap.
+			"import_path": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				ForceNew:      true,
+				ConflictsWith: []string{"build_local_path", "build_remote_path", "load_path", "pull"},
+			},
+
+			"import_message": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: true,
+			},
+
+			// Dockerfile instructions applied to the imported image,
+			// e.g. "CMD [\"/bin/sh\"]".
+			"import_changes": {
+				Type:     schema.TypeList,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+				Optional: true,
+				ForceNew: true,
 			},
 
 			"pull": {
 				Type:          schema.TypeBool,
 				Optional:      true,
 				ForceNew:      true,
-				ConflictsWith: []string{"build_local_path", "build_remote_path", "load_path"},
+				ConflictsWith: []string{"build_local_path", "build_remote_path", "load_path", "import_path"},
+			},
+
+			// Check the digest of tag in the registry on refresh and
+			// pull again when it moved. Only applies with pull.
+			"track_remote_digest": {
+				Type:     schema.TypeBool,
+				Optional: true,
+			},
+
+			"remote_digest": {
+				Type:     schema.TypeString,
+				Computed: true,
 			},
 
 			"keep": {
@@ -109,22 +177,226 @@ func resourceDockerImage() *schema.Resource {
 				Optional: true,
 			},
 
+			// What to do on destroy when containers use the image: "error",
+			// "skip" (leave the image in place) or "force".
+			"delete_policy": {
+				Type:     schema.TypeString,
+				Optional: true,
+				Default:  "error",
+				ValidateFunc: func(v interface{}, k string) (ws []string, es []error) {
+					value := v.(string)
+					if !regexp.MustCompile(`^(error|skip|force)$`).MatchString(value) {
+						es = append(es, fmt.Errorf(
+							"%q must be one of \"error\", \"skip\" or \"force\"", k))
+					}
+					return
+				},
+			},
+
+			// Keep untagged parent images on destroy.
+			"noprune": {
+				Type:     schema.TypeBool,
+				Optional: true,
+			},
+
 			"push": {
 				Type:     schema.TypeBool,
 				Optional: true,
 			},
 
+			// Additional repositories the image is tagged as and pushed
+			// to, each with its own credentials.
+			"push_targets": {
+				Type:     schema.TypeList,
+				Optional: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"registry": {
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"repository": {
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"tag": {
+							Type:     schema.TypeString,
+							Optional: true,
+							Default:  "latest",
+						},
+						"username": {
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"password": {
+							Type:      schema.TypeString,
+							Optional:  true,
+							Sensitive: true,
+						},
+					},
+				},
+			},
+
+			// Digest reported by the registry for each pushed
+			// repository:tag.
+			"push_digests": {
+				Type:     schema.TypeMap,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+				Computed: true,
+			},
+
 			"nocache": {
 				Type:     schema.TypeBool,
 				Optional: true,
 			},
 
+			// Write the image to a tar archive, in the format
+			// consumed by load_path.
+			"save_path": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+
+			"save_sha256": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+
 			"dockerfile": {
 				Type:     schema.TypeString,
 				Optional: true,
 				ForceNew: true,
 			},
 
+			// Inline Dockerfile, written to the build context under the
+			// dockerfile name.
+			"dockerfile_content": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				ForceNew:      true,
+				ConflictsWith: []string{"build_remote_path", "load_path", "import_path", "pull"},
+			},
+
+			// Files added to the build context, by path.
+			"context_files": {
+				Type:          schema.TypeMap,
+				Elem:          &schema.Schema{Type: schema.TypeString},
+				Optional:      true,
+				ForceNew:      true,
+				ConflictsWith: []string{"build_remote_path", "load_path", "import_path", "pull"},
+			},
+
+			"context_files_base64": {
+				Type:          schema.TypeMap,
+				Elem:          &schema.Schema{Type: schema.TypeString},
+				Optional:      true,
+				ForceNew:      true,
+				ConflictsWith: []string{"build_remote_path", "load_path", "import_path", "pull"},
+			},
+
+			// Hash of the build context, the Dockerfile and build_args.
+			// A change forces a rebuild.
+			"context_digest": {
+				Type:     schema.TypeString,
+				Computed: true,
+				ForceNew: true,
+			},
+
+			// Arbitrary values that force a rebuild when they change.
+			"triggers": {
+				Type:     schema.TypeMap,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+				Optional: true,
+				ForceNew: true,
+			},
+
+			// Build stage of a multi-stage Dockerfile.
+			"target": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: true,
+			},
+
+			"cache_from": {
+				Type:     schema.TypeList,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+				Optional: true,
+				ForceNew: true,
+			},
+
+			// go-dockerclient sends a single extrahosts value, so only one
+			// entry is supported.
+			"extra_hosts": {
+				Type:     schema.TypeSet,
+				Optional: true,
+				ForceNew: true,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"ip": {
+							Type:     schema.TypeString,
+							Required: true,
+							ForceNew: true,
+						},
+
+						"host": {
+							Type:     schema.TypeString,
+							Required: true,
+							ForceNew: true,
+						},
+					},
+				},
+				Set: resourceDockerHostsHash,
+			},
+
+			"platform": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: true,
+			},
+
+			// Squash the layers of the build into a single one
+			"squash": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				ForceNew: true,
+			},
+
+			"isolation": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: true,
+				ValidateFunc: func(v interface{}, k string) (ws []string, es []error) {
+					value := v.(string)
+					if !regexp.MustCompile(`^(default|process|hyperv)$`).MatchString(value) {
+						es = append(es, fmt.Errorf(
+							"%q must be one of \"default\", \"process\" or \"hyperv\"", k))
+					}
+					return
+				},
+			},
+
+			"shm_size": {
+				Type:     schema.TypeInt,
+				Optional: true,
+				ForceNew: true,
+			},
+
+			// Remove intermediate containers after a successful build.
+			"rm": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				ForceNew: true,
+				Default:  true,
+			},
+
+			// Always remove intermediate containers.
+			"force_rm": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				ForceNew: true,
+			},
+
 			"id": {
 				Type:     schema.TypeString,
 				Computed: true,
@@ -308,15 +580,12 @@ func resourceDockerImageCreate(d *schema.ResourceData, meta interface{}) error {
 	if err != nil {
 		return err
 	}
-	authConfig, err := getAuthConfig(d)
+	authConfig, err := getAuthConfig(d, resolvedConfig, dockerImageRegistries(d)...)
 	if err != nil {
 		return err
 	}
 
-	repoName := d.Get("name").(string)
-	if d.Get("registry").(string) != "" {
-		repoName = strings.Join([]string{d.Get("registry").(string), repoName}, "/")
-	}
+	repoName := dockerImageRepoName(d)
 
 	imageName := repoName
 	if d.Get("tag").(string) != "" {
@@ -334,7 +603,12 @@ func resourceDockerImageCreate(d *schema.ResourceData, meta interface{}) error {
 			return err
 		}
 	case d.Get("load_path").(string) != "":
-		fh, err := os.OpenFile(d.Get("load_path").(string), os.O_RDONLY, 0600)
+		loadPath := d.Get("load_path").(string)
+		imageIDs, loadedTags, err := readDockerImageArchive(loadPath)
+		if err != nil {
+			return fmt.Errorf("Unable to read image archive %s: %s", loadPath, err)
+		}
+		fh, err := os.OpenFile(loadPath, os.O_RDONLY, 0600)
 		if err != nil {
 			return err
 		}
@@ -345,7 +619,15 @@ func resourceDockerImageCreate(d *schema.ResourceData, meta interface{}) error {
 		if err != nil {
 			return err
 		}
-	case d.Get("build_local_path").(string) != "" || d.Get("build_remote_path").(string) != "":
+		d.Set("loaded_tags", loadedTags)
+		if err := tagLoadedDockerImage(d, client, imageName, imageIDs, loadedTags); err != nil {
+			return err
+		}
+	case d.Get("import_path").(string) != "":
+		if err := importDockerImage(d, client, repoName, imageName); err != nil {
+			return err
+		}
+	case dockerImageIsBuilt(d):
 		ulimitMap := make(map[string]*docker.ULimit)
 		for ulimitName, ulimitSoft := range d.Get("ulimit_soft").(map[string]interface{}) {
 			ulimit, ok := ulimitMap[ulimitName]
@@ -380,129 +662,1104 @@ func resourceDockerImageCreate(d *schema.ResourceData, meta interface{}) error {
 			labelMap[k] = v.(string)
 		}
 
-		buf := new(bytes.Buffer)
-
-		err := client.BuildImage(docker.BuildImageOptions{
-			Name:              imageName,
-			Dockerfile:        d.Get("dockerfile").(string),
-			SuppressOutput:    false,
-			OutputStream:      buf,
-			NoCache:           d.Get("nocache").(bool),
-			Pull:              d.Get("pull").(bool),
-			Memory:            int64(d.Get("memory").(int)),
-			Memswap:           int64(d.Get("memswap").(int)),
-			CPUShares:         int64(d.Get("cpu_shares").(int)),
-			CPUQuota:          int64(d.Get("cpu_quota").(int)),
-			CPUPeriod:         int64(d.Get("cpu_period").(int)),
-			CPUSetCPUs:        d.Get("cpu_set_cpus").(string),
-			NetworkMode:       d.Get("networkmode").(string),
-			CgroupParent:      d.Get("cgroup_parent").(string),
-			InactivityTimeout: time.Duration(d.Get("timeout").(int)) * time.Second,
-			Labels:            labelMap,
-			Remote:            d.Get("build_remote_path").(string),
-			ContextDir:        d.Get("build_local_path").(string),
+		var extraHosts string
+		if v, ok := d.GetOk("extra_hosts"); ok {
+			extraHosts = strings.Join(extraHostsSetToDockerExtraHosts(v.(*schema.Set)), ",")
+		}
+
+		var inputStream io.Reader
+		if d.Get("build_local_path").(string) != "" || inlineDockerBuildContext(d) {
+			buf, err := dockerBuildContextTar(d)
+			if err != nil {
+				return err
+			}
+			inputStream = buf
+		}
+
+		buildAuthConfig, err := getAllAuthConfig(d, resolvedConfig)
+		if err != nil {
+			return err
+		}
+
+		builtImageID, err := buildDockerImage(client, docker.BuildImageOptions{
+			Name:                imageName,
+			Dockerfile:          d.Get("dockerfile").(string),
+			SuppressOutput:      false,
+			Target:              d.Get("target").(string),
+			CacheFrom:           stringListToStringSlice(d.Get("cache_from").([]interface{})),
+			ExtraHosts:          extraHosts,
+			Platform:            d.Get("platform").(string),
+			ShmSize:             int64(d.Get("shm_size").(int)),
+			RmTmpContainer:      d.Get("rm").(bool),
+			ForceRmTmpContainer: d.Get("force_rm").(bool),
+			NoCache:             d.Get("nocache").(bool),
+			Pull:                d.Get("pull").(bool),
+			Memory:              int64(d.Get("memory").(int)),
+			Memswap:             int64(d.Get("memswap").(int)),
+			CPUShares:           int64(d.Get("cpu_shares").(int)),
+			CPUQuota:            int64(d.Get("cpu_quota").(int)),
+			CPUPeriod:           int64(d.Get("cpu_period").(int)),
+			CPUSetCPUs:          d.Get("cpu_set_cpus").(string),
+			NetworkMode:         d.Get("networkmode").(string),
+			CgroupParent:        d.Get("cgroup_parent").(string),
+			InactivityTimeout:   time.Duration(d.Get("timeout").(int)) * time.Second,
+			Labels:              labelMap,
+			Remote:              d.Get("build_remote_path").(string),
+			InputStream:         inputStream,
 			AuthConfigs: docker.AuthConfigurations{
-				Configs: authConfig,
+				Configs: buildAuthConfig,
 			},
 			Ulimits:   ulimitList,
 			BuildArgs: buildArgList,
-		})
-		log.Printf("docker build command output: %s\n", buf.String())
+		}, d.Get("squash").(bool), d.Get("isolation").(string), dockerfileForBuild(d))
 		if err != nil {
 			return err
 		}
+		d.Set("id", builtImageID)
 	}
 
-	if d.Get("push").(bool) {
-		err := client.PushImage(docker.PushImageOptions{
-			Name:              strings.Join([]string{d.Get("registry").(string), d.Get("name").(string)}, "/"),
-			Registry:          d.Get("registry").(string),
-			Tag:               d.Get("tag").(string),
-			InactivityTimeout: time.Duration(d.Get("timeout").(int)) * time.Second,
-		}, authConfig[d.Get("registry").(string)])
+	if err := pushDockerImage(d, client, repoName, authConfig, true); err != nil {
+		return err
+	}
+
+	d.SetId(imageName)
+
+	if d.Get("build_local_path").(string) != "" || inlineDockerBuildContext(d) {
+		digest, err := dockerBuildContextDigest(d)
 		if err != nil {
 			return err
 		}
+		d.Set("context_digest", digest)
+	}
+
+	if v, ok := d.GetOk("save_path"); ok {
+		if err := saveDockerImage(d, client, v.(string)); err != nil {
+			return err
+		}
 	}
 
-	d.SetId(imageName)
 	return resourceDockerImageRead(d, meta)
 }
 
-func resourceDockerImageRead(d *schema.ResourceData, meta interface{}) error {
-	providerConfig := meta.(*ProviderConfig)
-	resolvedConfig, _, err := providerConfig.GetResolvedConfig(d)
-	if err != nil {
-		return err
-	}
-	client, err := resolvedConfig.NewClient()
-	if err != nil {
-		return err
-	}
+// Number of build output lines reported when a build fails.
+const buildFailureOutputLines = 20
 
-	image, err := client.InspectImage(d.Id())
-	if err != nil {
-		d.SetId("")
-		return err
-	}
-	d.Set("id", image.ID)
-	d.Set("parent", image.Parent)
-	d.Set("comment", image.Comment)
-	d.Set("docker_version", image.DockerVersion)
-	d.Set("author", image.Author)
-	d.Set("architecture", image.Architecture)
-	d.Set("size", image.Size)
-	d.Set("virtual_size", image.VirtualSize)
-	d.Set("os", image.OS)
-	d.Set("created_at", image.Created.Unix())
-	d.Set("labels", image.Config.Labels)
-	d.Set("digests", image.RepoDigests)
-	d.Set("all_tags", image.RepoTags)
-	return nil
+// dockerBuildOutput follows the JSON stream of a build, logging each line
+// as it arrives and keeping what is needed to report the result.
+type dockerBuildOutput struct {
+	step      string
+	lastLines []string
+	imageID   string
+	errorText string
 }
 
-func getAuthConfig(d *schema.ResourceData) (map[string]docker.AuthConfiguration, error) {
-	authConfig := make(map[string]docker.AuthConfiguration)
-	authList := d.Get("auth").([]interface{})
-	for _, authEntryIf := range authList {
-		authEntry := authEntryIf.(map[string]interface{})
-		authConfig[authEntry["registry"].(string)] = docker.AuthConfiguration{
-			Username: authEntry["username"].(string),
-			Password: authEntry["password"].(string),
+type dockerBuildMessage struct {
+	Stream      string `json:"stream"`
+	Status      string `json:"status"`
+	Error       string `json:"error"`
+	ErrorDetail struct {
+		Message string `json:"message"`
+	} `json:"errorDetail"`
+	Aux struct {
+		ID string `json:"ID"`
+	} `json:"aux"`
+}
+
+func (o *dockerBuildOutput) consume(r io.Reader) error {
+	decoder := json.NewDecoder(r)
+	for {
+		var msg dockerBuildMessage
+		if err := decoder.Decode(&msg); err == io.EOF {
+			return nil
+		} else if err != nil {
+			// Keep the build going, the writer must not block
+			io.Copy(ioutil.Discard, r)
+			return err
+		}
+
+		switch {
+		case msg.Error != "":
+			o.errorText = msg.Error
+			if msg.ErrorDetail.Message != "" {
+				o.errorText = msg.ErrorDetail.Message
+			}
+			log.Printf("[ERROR] docker build: %s", o.errorText)
+		case msg.Aux.ID != "":
+			o.imageID = msg.Aux.ID
+		case msg.Status != "":
+			log.Printf("[DEBUG] docker build: %s", msg.Status)
+		}
+
+		for _, line := range strings.Split(msg.Stream, "\n") {
+			line = strings.TrimRight(line, "\r")
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			log.Printf("[INFO] docker build: %s", line)
+			if strings.HasPrefix(line, "Step ") {
+				o.step = line
+			}
+			if strings.HasPrefix(line, "Successfully built ") && o.imageID == "" {
+				o.imageID = strings.TrimPrefix(line, "Successfully built ")
+			}
+			o.lastLines = append(o.lastLines, line)
+			if len(o.lastLines) > buildFailureOutputLines {
+				o.lastLines = o.lastLines[1:]
+			}
 		}
 	}
-	return authConfig, nil
 }
 
-func resourceDockerImageUpdate(d *schema.ResourceData, meta interface{}) error {
-	providerConfig := meta.(*ProviderConfig)
-	resolvedConfig, _, err := providerConfig.GetResolvedConfig(d)
-	if err != nil {
-		return err
-	}
-	client, err := resolvedConfig.NewClient()
-	if err != nil {
-		return err
+// failingInstruction returns the Dockerfile instruction of the current step,
+// e.g. "RUN make" for "Step 3/5 : RUN make".
+func (o *dockerBuildOutput) failingInstruction() string {
+	if i := strings.Index(o.step, " : "); i >= 0 {
+		return strings.TrimSpace(o.step[i+3:])
 	}
+	return ""
+}
 
-	authConfig, err := getAuthConfig(d)
-	if err != nil {
-		return err
+// buildDockerImage runs a build, streaming its output to the log, and
+// returns the ID of the resulting image. A failure reports the failing step,
+// its line in dockerfile when known, and the last lines of output.
+func buildDockerImage(client *docker.Client, opts docker.BuildImageOptions, squash bool, isolation string, dockerfile []byte) (string, error) {
+	pr, pw := io.Pipe()
+	output := &dockerBuildOutput{}
+	done := make(chan error, 1)
+	go func() {
+		done <- output.consume(pr)
+	}()
+
+	opts.OutputStream = pw
+	err := dockerBuildImage(client, opts, squash, isolation)
+	pw.Close()
+	if decodeErr := <-done; decodeErr != nil {
+		log.Printf("[WARN] Unable to parse docker build output: %s", decodeErr)
 	}
 
-	if d.HasChange("push") && d.Get("push").(bool) {
-		err := client.PushImage(docker.PushImageOptions{
-			Name:              strings.Join([]string{d.Get("registry").(string), d.Get("name").(string)}, "/"),
-			Registry:          d.Get("registry").(string),
-			Tag:               d.Get("tag").(string),
-			InactivityTimeout: time.Duration(d.Get("timeout").(int)) * time.Second,
-		}, authConfig[d.Get("registry").(string)])
-		if err != nil {
-			return err
+	if err == nil && output.errorText == "" {
+		if output.imageID == "" {
+			image, err := client.InspectImage(opts.Name)
+			if err != nil {
+				return "", err
+			}
+			return image.ID, nil
 		}
+		return output.imageID, nil
 	}
-	return nil
-}
+
+	msg := output.errorText
+	if msg == "" {
+		msg = err.Error()
+	}
+	var details []string
+	if output.step != "" {
+		details = append(details, "Failing step: "+output.step)
+		if line := findDockerfileLine(dockerfile, output.failingInstruction()); line > 0 {
+			details = append(details, fmt.Sprintf("Dockerfile line: %d", line))
+		}
+	}
+	if len(output.lastLines) > 0 {
+		details = append(details, "Last output:\n"+strings.Join(output.lastLines, "\n"))
+	}
+	if len(details) == 0 {
+		return "", fmt.Errorf("Unable to build image %s: %s", opts.Name, msg)
+	}
+	return "", fmt.Errorf("Unable to build image %s: %s\n%s", opts.Name, msg, strings.Join(details, "\n"))
+}
+
+// dockerfileName returns the path of the Dockerfile inside the build
+// context.
+func dockerfileName(d ResourceConfig) string {
+	if dockerfile := d.Get("dockerfile").(string); dockerfile != "" {
+		return dockerfile
+	}
+	return "Dockerfile"
+}
+
+// dockerfileForBuild returns the content of the Dockerfile used by the
+// build, or nil when it is not available locally.
+func dockerfileForBuild(d ResourceConfig) []byte {
+	if v := d.Get("dockerfile_content").(string); v != "" {
+		return []byte(v)
+	}
+	if contextDir := d.Get("build_local_path").(string); contextDir != "" {
+		content, err := ioutil.ReadFile(filepath.Join(contextDir, dockerfileName(d)))
+		if err == nil {
+			return content
+		}
+	}
+	return nil
+}
+
+// findDockerfileLine returns the line number where instruction starts in
+// the Dockerfile, or 0 when it can not be found.
+func findDockerfileLine(dockerfile []byte, instruction string) int {
+	if len(dockerfile) == 0 || instruction == "" {
+		return 0
+	}
+	fields := strings.Fields(instruction)
+	for i, line := range strings.Split(string(dockerfile), "\n") {
+		lineFields := strings.Fields(strings.TrimSuffix(strings.TrimSpace(line), "\\"))
+		if len(lineFields) == 0 || len(lineFields) > len(fields) || !strings.EqualFold(lineFields[0], fields[0]) {
+			continue
+		}
+		match := true
+		for j := 1; j < len(lineFields); j++ {
+			if lineFields[j] != fields[j] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func resourceDockerImageCustomizeDiff(d *schema.ResourceDiff, meta interface{}) error {
+	// Pull again when the tag moved in the registry, as found on refresh
+	if d.Id() != "" && d.Get("pull").(bool) && d.Get("track_remote_digest").(bool) {
+		if remoteDigest := d.Get("remote_digest").(string); remoteDigest != "" {
+			found := false
+			for _, digest := range d.Get("digests").([]interface{}) {
+				if strings.HasSuffix(digest.(string), "@"+remoteDigest) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				log.Printf("[INFO] %s moved to %s in the registry", d.Id(), remoteDigest)
+				if err := d.SetNewComputed("digests"); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	for _, key := range []string{"build_local_path", "build_args", "dockerfile", "dockerfile_content", "context_files", "context_files_base64"} {
+		if !d.NewValueKnown(key) {
+			return d.SetNewComputed("context_digest")
+		}
+	}
+	buildLocalPath := d.Get("build_local_path").(string)
+	if buildLocalPath == "" && !inlineDockerBuildContext(d) {
+		return nil
+	}
+	// The context may be generated by another resource during the apply
+	if buildLocalPath != "" {
+		if _, err := os.Stat(buildLocalPath); os.IsNotExist(err) {
+			return d.SetNewComputed("context_digest")
+		}
+	}
+	digest, err := dockerBuildContextDigest(d)
+	if err != nil {
+		return err
+	}
+	if digest != d.Get("context_digest").(string) {
+		return d.SetNew("context_digest", digest)
+	}
+	return nil
+}
+
+// dockerImageRepoName returns the repository of the image, prefixed with
+// registry when one is set.
+func dockerImageRepoName(d ResourceConfig) string {
+	if registry := d.Get("registry").(string); registry != "" {
+		return registry + "/" + d.Get("name").(string)
+	}
+	return d.Get("name").(string)
+}
+
+// dockerImageIsBuilt reports whether the image is built rather than
+// pulled, loaded or imported.
+func dockerImageIsBuilt(d ResourceConfig) bool {
+	return d.Get("build_local_path").(string) != "" || d.Get("build_remote_path").(string) != "" || inlineDockerBuildContext(d)
+}
+
+// inlineDockerBuildContext reports whether the build context is generated
+// by the provider rather than read by go-dockerclient from build_local_path.
+func inlineDockerBuildContext(d ResourceConfig) bool {
+	return d.Get("dockerfile_content").(string) != "" ||
+		len(d.Get("context_files").(map[string]interface{})) > 0 ||
+		len(d.Get("context_files_base64").(map[string]interface{})) > 0
+}
+
+// dockerBuildContextFiles returns the files set in the configuration,
+// dockerfile_content included, by their path in the build context.
+func dockerBuildContextFiles(d ResourceConfig) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	for name, content := range d.Get("context_files").(map[string]interface{}) {
+		files[path.Clean(name)] = []byte(content.(string))
+	}
+	for name, content := range d.Get("context_files_base64").(map[string]interface{}) {
+		data, err := base64.StdEncoding.DecodeString(content.(string))
+		if err != nil {
+			return nil, fmt.Errorf("Unable to decode context_files_base64 for %s: %s", name, err)
+		}
+		files[path.Clean(name)] = data
+	}
+	if v := d.Get("dockerfile_content").(string); v != "" {
+		files[path.Clean(dockerfileName(d))] = []byte(v)
+	}
+	return files, nil
+}
+
+// walkDockerBuildContext calls fn for every entry of contextDir that docker
+// build would send, skipping what .dockerignore excludes. The Dockerfile and
+// .dockerignore are always sent.
+func walkDockerBuildContext(contextDir string, dockerfile string, fn func(rel string, filename string, info os.FileInfo) error) error {
+	excludes, err := readDockerIgnore(filepath.Join(contextDir, ".dockerignore"))
+	if err != nil {
+		return fmt.Errorf("Unable to read .dockerignore: %s", err)
+	}
+
+	err = filepath.Walk(contextDir, func(filename string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(contextDir, filename)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if rel != dockerfile && rel != ".dockerignore" && excludes.matches(rel) {
+			if info.IsDir() && !excludes.hasExceptions {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		return fn(rel, filename, info)
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to read build context %s: %s", contextDir, err)
+	}
+	return nil
+}
+
+// dockerBuildContextTar archives build_local_path together with
+// context_files and dockerfile_content, which take precedence over local
+// files at the same path.
+func dockerBuildContextTar(d ResourceConfig) (*bytes.Buffer, error) {
+	files, err := dockerBuildContextFiles(d)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+
+	if contextDir := d.Get("build_local_path").(string); contextDir != "" {
+		err := walkDockerBuildContext(contextDir, dockerfileName(d), func(rel string, filename string, info os.FileInfo) error {
+			if _, ok := files[rel]; ok {
+				return nil
+			}
+			link := ""
+			if info.Mode()&os.ModeSymlink != 0 {
+				var err error
+				if link, err = os.Readlink(filename); err != nil {
+					return err
+				}
+			}
+			hdr, err := tar.FileInfoHeader(info, link)
+			if err != nil {
+				return err
+			}
+			hdr.Name = rel
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+			fh, err := os.Open(filename)
+			if err != nil {
+				return err
+			}
+			defer fh.Close()
+			_, err = io.Copy(tw, fh)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := writeFileToTar(tw, name, files[name], tarFileOptions{}); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("Error creating tar archive: %s", err)
+	}
+	return buf, nil
+}
+
+// dockerBuildContextDigest hashes what docker build would send: every file
+// of build_local_path not excluded by .dockerignore, its path, mode and
+// content, the files set in the configuration, the Dockerfile name and the
+// build arguments.
+func dockerBuildContextDigest(d ResourceConfig) (string, error) {
+	files, err := dockerBuildContextFiles(d)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "dockerfile %s\n", dockerfileName(d))
+
+	buildArgs := d.Get("build_args").(map[string]interface{})
+	var buildArgNames []string
+	for k := range buildArgs {
+		buildArgNames = append(buildArgNames, k)
+	}
+	sort.Strings(buildArgNames)
+	for _, k := range buildArgNames {
+		fmt.Fprintf(hash, "arg %s=%s\n", k, buildArgs[k].(string))
+	}
+
+	if contextDir := d.Get("build_local_path").(string); contextDir != "" {
+		err := walkDockerBuildContext(contextDir, dockerfileName(d), func(rel string, filename string, info os.FileInfo) error {
+			if _, ok := files[rel]; ok {
+				return nil
+			}
+			fmt.Fprintf(hash, "file %s %o\n", rel, info.Mode())
+			switch {
+			case info.Mode()&os.ModeSymlink != 0:
+				target, err := os.Readlink(filename)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(hash, "link %s\n", target)
+			case info.Mode().IsRegular():
+				fh, err := os.Open(filename)
+				if err != nil {
+					return err
+				}
+				defer fh.Close()
+				if _, err := io.Copy(hash, fh); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(hash, "inline %s %d\n", name, len(files[name]))
+		hash.Write(files[name])
+	}
+
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+type dockerIgnorePattern struct {
+	re        *regexp.Regexp
+	exception bool
+}
+
+type dockerIgnore struct {
+	patterns      []dockerIgnorePattern
+	hasExceptions bool
+}
+
+// readDockerIgnore parses a .dockerignore file. A missing file excludes
+// nothing.
+func readDockerIgnore(filename string) (*dockerIgnore, error) {
+	ignore := &dockerIgnore{}
+	content, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return ignore, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		exception := strings.HasPrefix(line, "!")
+		if exception {
+			line = strings.TrimSpace(line[1:])
+			ignore.hasExceptions = true
+		}
+		line = strings.TrimPrefix(path.Clean(filepath.ToSlash(line)), "/")
+		re, err := dockerIgnorePatternRegexp(line)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid pattern %q: %s", line, err)
+		}
+		ignore.patterns = append(ignore.patterns, dockerIgnorePattern{re: re, exception: exception})
+	}
+	return ignore, nil
+}
+
+// dockerIgnorePatternRegexp translates a .dockerignore pattern the way docker
+// does: "**" matches any number of directories, "*" and "?" do not cross a
+// "/".
+func dockerIgnorePatternRegexp(pattern string) (*regexp.Regexp, error) {
+	var buf bytes.Buffer
+	buf.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+				if i+1 < len(pattern) && pattern[i+1] == '/' {
+					i++
+					buf.WriteString("(.*/)?")
+				} else {
+					buf.WriteString(".*")
+				}
+			} else {
+				buf.WriteString("[^/]*")
+			}
+		case '?':
+			buf.WriteString("[^/]")
+		case '[':
+			j := strings.IndexByte(pattern[i:], ']')
+			if j < 0 {
+				return nil, fmt.Errorf("unterminated character class")
+			}
+			buf.WriteString(pattern[i : i+j+1])
+			i += j
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+			buf.WriteString(regexp.QuoteMeta(string(pattern[i])))
+		default:
+			buf.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	buf.WriteString("$")
+	return regexp.Compile(buf.String())
+}
+
+// matches reports whether rel is excluded. As with docker, a pattern matching
+// a parent directory excludes everything below it and the last matching
+// pattern wins.
+func (ignore *dockerIgnore) matches(rel string) bool {
+	excluded := false
+	for _, p := range ignore.patterns {
+		match := false
+		for candidate := rel; candidate != "."; candidate = path.Dir(candidate) {
+			if p.re.MatchString(candidate) {
+				match = true
+				break
+			}
+		}
+		if match {
+			excluded = !p.exception
+		}
+	}
+	return excluded
+}
+
+// importDockerImage imports import_path as imageName. The import API of
+// go-dockerclient takes neither a message nor changes, so the request is
+// sent directly. import_path is fetched by the daemon when it is an http
+// or https URL and uploaded as a local file otherwise.
+func importDockerImage(d *schema.ResourceData, client *docker.Client, repoName string, imageName string) error {
+	importPath := d.Get("import_path").(string)
+
+	query := url.Values{}
+	query.Set("repo", repoName)
+	query.Set("tag", d.Get("tag").(string))
+	if v, ok := d.GetOk("import_message"); ok {
+		query.Set("message", v.(string))
+	}
+	if v, ok := d.GetOk("import_changes"); ok {
+		for _, change := range stringListToStringSlice(v.([]interface{})) {
+			query.Add("changes", change)
+		}
+	}
+
+	var body io.Reader
+	if u, err := url.Parse(importPath); err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" {
+		query.Set("fromSrc", importPath)
+	} else {
+		fh, err := os.Open(importPath)
+		if os.IsNotExist(err) {
+			return fmt.Errorf("Unable to import %s: file not found", importPath)
+		}
+		if err != nil {
+			return fmt.Errorf("Unable to open %s: %s", importPath, err)
+		}
+		defer fh.Close()
+		query.Set("fromSrc", "-")
+		body = fh
+	}
+
+	resp, err := dockerAPIRequest(client, "POST", "/images/create", query, body, "application/x-tar")
+	if err != nil {
+		return fmt.Errorf("Unable to import %s as %s: %s", importPath, imageName, err)
+	}
+	defer resp.Body.Close()
+
+	// Errors are reported in the progress stream
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var msg dockerBuildMessage
+		if err := decoder.Decode(&msg); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("Unable to import %s as %s: %s", importPath, imageName, err)
+		}
+		if msg.Error != "" {
+			return fmt.Errorf("Unable to import %s as %s: %s", importPath, imageName, msg.Error)
+		}
+	}
+
+	return nil
+}
+
+// tagLoadedDockerImage makes sure imageName refers to the image loaded from
+// load_path, tagging it when retag_loaded is set.
+func tagLoadedDockerImage(d *schema.ResourceData, client *docker.Client, imageName string, imageIDs []string, loadedTags []string) error {
+	for _, tag := range loadedTags {
+		if tag == imageName {
+			return nil
+		}
+	}
+	if !d.Get("retag_loaded").(bool) {
+		return fmt.Errorf("Image archive %s does not contain %s (found %s); set retag_loaded to tag the loaded image",
+			d.Get("load_path").(string), imageName, strings.Join(loadedTags, ", "))
+	}
+
+	var source string
+	switch {
+	case len(imageIDs) == 1:
+		source = imageIDs[0]
+	case len(imageIDs) == 0 && len(loadedTags) == 1:
+		source = loadedTags[0]
+	default:
+		return fmt.Errorf("Image archive %s does not contain exactly one image, unable to tag it as %s",
+			d.Get("load_path").(string), imageName)
+	}
+
+	repo, tag := imageName, ""
+	if i := strings.LastIndex(imageName, ":"); i > strings.LastIndex(imageName, "/") {
+		repo, tag = imageName[:i], imageName[i+1:]
+	}
+	log.Printf("[DEBUG] Tagging loaded image %s as %s", source, imageName)
+	err := client.TagImage(source, docker.TagImageOptions{
+		Repo:  repo,
+		Tag:   tag,
+		Force: true,
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to tag image %s as %s: %s", source, imageName, err)
+	}
+	return nil
+}
+
+// readDockerImageArchive lists the image IDs and repository tags of an
+// archive produced by docker save, from manifest.json or, for archives in
+// the legacy format, from repositories. Gzip compressed archives are
+// accepted like docker load does.
+func readDockerImageArchive(filename string) ([]string, []string, error) {
+	fh, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer fh.Close()
+
+	br := bufio.NewReader(fh)
+	var r io.Reader = br
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	var manifest []struct {
+		Config   string
+		RepoTags []string
+	}
+	var repositories map[string]map[string]string
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		switch path.Clean(hdr.Name) {
+		case "manifest.json":
+			if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+				return nil, nil, fmt.Errorf("Invalid manifest.json: %s", err)
+			}
+		case "repositories":
+			if err := json.NewDecoder(tr).Decode(&repositories); err != nil {
+				return nil, nil, fmt.Errorf("Invalid repositories: %s", err)
+			}
+		}
+	}
+
+	var imageIDs, tags []string
+	if manifest != nil {
+		for _, entry := range manifest {
+			imageIDs = append(imageIDs, "sha256:"+strings.TrimSuffix(path.Base(entry.Config), ".json"))
+			tags = append(tags, entry.RepoTags...)
+		}
+		return imageIDs, tags, nil
+	}
+	for repo, repoTags := range repositories {
+		for tag := range repoTags {
+			tags = append(tags, repo+":"+tag)
+		}
+	}
+	sort.Strings(tags)
+	return imageIDs, tags, nil
+}
+
+// pushDockerImage pushes repoName:tag when push is set and the image to
+// each of push_targets, recording the digests in push_digests. On update
+// only what changed is pushed again.
+func pushDockerImage(d *schema.ResourceData, client *docker.Client, repoName string, authConfig map[string]docker.AuthConfiguration, all bool) error {
+	imageName := repoName + ":" + d.Get("tag").(string)
+	digests := make(map[string]interface{})
+	for k, v := range d.Get("push_digests").(map[string]interface{}) {
+		digests[k] = v
+	}
+	wanted := make(map[string]bool)
+
+	if d.Get("push").(bool) {
+		wanted[imageName] = true
+		if _, ok := digests[imageName]; all || d.HasChange("push") || !ok {
+			registry := d.Get("registry").(string)
+			digest, err := pushDockerImageName(d, client, repoName, d.Get("tag").(string), authConfig[registry])
+			if err != nil {
+				return err
+			}
+			digests[imageName] = digest
+		}
+	}
+
+	for _, targetIf := range d.Get("push_targets").([]interface{}) {
+		target := targetIf.(map[string]interface{})
+		registry := target["registry"].(string)
+		targetRepo := target["repository"].(string)
+		if registry != "" {
+			targetRepo = registry + "/" + targetRepo
+		}
+		targetTag := target["tag"].(string)
+		targetName := targetRepo + ":" + targetTag
+		wanted[targetName] = true
+		if _, ok := digests[targetName]; !all && !d.HasChange("push_targets") && ok {
+			continue
+		}
+
+		auth := authConfig[registry]
+		if username := target["username"].(string); username != "" {
+			auth = docker.AuthConfiguration{
+				Username:      username,
+				Password:      target["password"].(string),
+				ServerAddress: registry,
+			}
+		}
+
+		err := client.TagImage(imageName, docker.TagImageOptions{
+			Repo:  targetRepo,
+			Tag:   targetTag,
+			Force: true,
+		})
+		if err != nil {
+			return fmt.Errorf("Unable to tag image %s as %s: %s", imageName, targetName, err)
+		}
+		digest, err := pushDockerImageName(d, client, targetRepo, targetTag, auth)
+		if err != nil {
+			return err
+		}
+		digests[targetName] = digest
+	}
+
+	for k := range digests {
+		if !wanted[k] {
+			delete(digests, k)
+		}
+	}
+	d.Set("push_digests", digests)
+	return nil
+}
+
+type dockerPushMessage struct {
+	Status      string `json:"status"`
+	Error       string `json:"error"`
+	ErrorDetail struct {
+		Message string `json:"message"`
+	} `json:"errorDetail"`
+	Aux struct {
+		Digest string `json:"Digest"`
+	} `json:"aux"`
+}
+
+// pushDockerImageName pushes repository:tag and returns the digest reported
+// by the registry.
+func pushDockerImageName(d *schema.ResourceData, client *docker.Client, repository string, tag string, auth docker.AuthConfiguration) (string, error) {
+	pr, pw := io.Pipe()
+	var digest, errorText string
+	done := make(chan error, 1)
+	go func() {
+		decoder := json.NewDecoder(pr)
+		for {
+			var msg dockerPushMessage
+			if err := decoder.Decode(&msg); err == io.EOF {
+				done <- nil
+				return
+			} else if err != nil {
+				io.Copy(ioutil.Discard, pr)
+				done <- err
+				return
+			}
+			switch {
+			case msg.Error != "":
+				errorText = msg.Error
+				if msg.ErrorDetail.Message != "" {
+					errorText = msg.ErrorDetail.Message
+				}
+			case msg.Aux.Digest != "":
+				digest = msg.Aux.Digest
+			case msg.Status != "":
+				log.Printf("[DEBUG] docker push %s:%s: %s", repository, tag, msg.Status)
+			}
+		}
+	}()
+
+	err := client.PushImage(docker.PushImageOptions{
+		Name:              repository,
+		Tag:               tag,
+		OutputStream:      pw,
+		RawJSONStream:     true,
+		InactivityTimeout: time.Duration(d.Get("timeout").(int)) * time.Second,
+	}, auth)
+	pw.Close()
+	if decodeErr := <-done; decodeErr != nil {
+		log.Printf("[WARN] Unable to parse docker push output: %s", decodeErr)
+	}
+	if err == nil && errorText != "" {
+		err = fmt.Errorf("%s", errorText)
+	}
+	if err != nil {
+		return "", fmt.Errorf("Unable to push image %s:%s: %s", repository, tag, err)
+	}
+	return digest, nil
+}
+
+func saveDockerImage(d *schema.ResourceData, client *docker.Client, savePath string) error {
+	sum, err := writeDockerArchive(savePath, func(w io.Writer) error {
+		return client.ExportImages(docker.ExportImagesOptions{
+			Names:             []string{d.Id()},
+			OutputStream:      w,
+			InactivityTimeout: time.Duration(d.Get("timeout").(int)) * time.Second,
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to save image %s to %s: %s", d.Id(), savePath, err)
+	}
+	d.Set("save_sha256", sum)
+	return nil
+}
+
+// writeDockerArchive writes the tar archive produced by export to filename
+// and returns its SHA-256. The file is only replaced once the archive is
+// complete.
+func writeDockerArchive(filename string, export func(io.Writer) error) (string, error) {
+	fh, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename))
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(fh.Name())
+
+	hash := sha256.New()
+	if err := export(io.MultiWriter(fh, hash)); err != nil {
+		fh.Close()
+		return "", err
+	}
+	if err := fh.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Chmod(fh.Name(), 0644); err != nil {
+		return "", err
+	}
+	if err := os.Rename(fh.Name(), filename); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func resourceDockerImageRead(d *schema.ResourceData, meta interface{}) error {
+	providerConfig := meta.(*ProviderConfig)
+	resolvedConfig, _, err := providerConfig.GetResolvedConfig(d)
+	if err != nil {
+		return err
+	}
+	client, err := resolvedConfig.NewClient()
+	if err != nil {
+		return err
+	}
+
+	// A built image is read by the ID reported by the build, the tag may
+	// have been moved to another image since.
+	ref := d.Id()
+	if id := d.Get("id").(string); id != "" && dockerImageIsBuilt(d) {
+		ref = id
+	}
+	image, err := client.InspectImage(ref)
+	if err != nil {
+		d.SetId("")
+		return err
+	}
+	d.Set("id", image.ID)
+	readDockerImageAttributes(d, image)
+
+	if d.Get("pull").(bool) && d.Get("track_remote_digest").(bool) {
+		registry := d.Get("registry").(string)
+		authConfig, err := getAuthConfig(d, resolvedConfig, registry)
+		if err != nil {
+			return err
+		}
+		manifest, err := newRegistryClient(registry, authConfig[registry]).fetchManifest(
+			registryRepository(registry, d.Get("name").(string)), d.Get("tag").(string), true)
+		if err != nil {
+			log.Printf("[WARN] Unable to check the remote digest of %s: %s", d.Id(), err)
+		} else {
+			d.Set("remote_digest", manifest.Digest)
+		}
+	}
+
+	// A missing archive is written again on the next apply
+	if v, ok := d.GetOk("save_path"); ok {
+		if _, err := os.Stat(v.(string)); os.IsNotExist(err) {
+			d.Set("save_path", "")
+		}
+	}
+	return nil
+}
+
+// readDockerImageAttributes sets the computed image metadata shared by the
+// resources and data sources that produce or look up images.
+func readDockerImageAttributes(d *schema.ResourceData, image *docker.Image) {
+	d.Set("parent", image.Parent)
+	d.Set("comment", image.Comment)
+	d.Set("docker_version", image.DockerVersion)
+	d.Set("author", image.Author)
+	d.Set("architecture", image.Architecture)
+	d.Set("size", image.Size)
+	d.Set("virtual_size", image.VirtualSize)
+	d.Set("os", image.OS)
+	d.Set("created_at", image.Created.Unix())
+	d.Set("labels", image.Config.Labels)
+	d.Set("digests", image.RepoDigests)
+	d.Set("all_tags", image.RepoTags)
+}
+
+// getAuthConfig returns the credentials of the given registries, by
+// registry. Nothing is looked up for other registries, so credential
+// helpers only run when needed; see registryAuthResolver for the
+// precedence of the sources.
+func getAuthConfig(d *schema.ResourceData, config *ProviderConfig, registries ...string) (map[string]docker.AuthConfiguration, error) {
+	resolver := newRegistryAuthResolver(resourceDockerAuth(d), config)
+	authConfig := make(map[string]docker.AuthConfiguration)
+	for _, registry := range registries {
+		if _, ok := authConfig[registry]; ok {
+			continue
+		}
+		auth, err := resolver.resolve(registry)
+		if err != nil {
+			return nil, err
+		}
+		authConfig[registry] = auth
+	}
+	return authConfig, nil
+}
+
+// getAllAuthConfig returns the credentials of every known registry, for
+// builds whose base images may come from any of them.
+func getAllAuthConfig(d *schema.ResourceData, config *ProviderConfig) (map[string]docker.AuthConfiguration, error) {
+	return newRegistryAuthResolver(resourceDockerAuth(d), config).resolveAll()
+}
+
+// resourceDockerAuth returns the credentials of the auth blocks, by
+// registry.
+func resourceDockerAuth(d *schema.ResourceData) map[string]docker.AuthConfiguration {
+	auths := make(map[string]docker.AuthConfiguration)
+	for _, authEntryIf := range d.Get("auth").([]interface{}) {
+		authEntry := authEntryIf.(map[string]interface{})
+		auths[authEntry["registry"].(string)] = docker.AuthConfiguration{
+			Username:      authEntry["username"].(string),
+			Password:      authEntry["password"].(string),
+			ServerAddress: authEntry["registry"].(string),
+		}
+	}
+	return auths
+}
+
+// dockerImageRegistries returns the registries the image is pulled from or
+// pushed to.
+func dockerImageRegistries(d *schema.ResourceData) []string {
+	registries := []string{d.Get("registry").(string)}
+	for _, targetIf := range d.Get("push_targets").([]interface{}) {
+		registries = append(registries, targetIf.(map[string]interface{})["registry"].(string))
+	}
+	return registries
+}
+
+func resourceDockerImageUpdate(d *schema.ResourceData, meta interface{}) error {
+	providerConfig := meta.(*ProviderConfig)
+	resolvedConfig, _, err := providerConfig.GetResolvedConfig(d)
+	if err != nil {
+		return err
+	}
+	client, err := resolvedConfig.NewClient()
+	if err != nil {
+		return err
+	}
+
+	authConfig, err := getAuthConfig(d, resolvedConfig, dockerImageRegistries(d)...)
+	if err != nil {
+		return err
+	}
+
+	repoName := dockerImageRepoName(d)
+
+	if d.HasChange("push") || d.HasChange("push_targets") {
+		if err := pushDockerImage(d, client, repoName, authConfig, false); err != nil {
+			return err
+		}
+	}
+
+	if v, ok := d.GetOk("save_path"); ok && d.HasChange("save_path") {
+		if err := saveDockerImage(d, client, v.(string)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
 func resourceDockerImageDelete(d *schema.ResourceData, meta interface{}) error {
 	providerConfig := meta.(*ProviderConfig)
@@ -519,14 +1776,85 @@ func resourceDockerImageDelete(d *schema.ResourceData, meta interface{}) error {
 		return nil
 	}
 
-	imageName := strings.Join([]string{d.Get("name").(string), d.Get("tag").(string)}, ":")
-	if d.Get("registry").(string) != "" {
-		imageName = strings.Join([]string{d.Get("registry").(string), imageName}, "/")
+	if err := removeDockerImage(d, client); err != nil {
+		return err
 	}
 
-	return client.RemoveImageExtended(imageName, docker.RemoveImageOptions{
-		Force: true,
+	d.SetId("")
+	return nil
+}
+
+// removeDockerImage removes the reference created by Create according to
+// delete_policy and noprune, unless it now points to another image.
+func removeDockerImage(d *schema.ResourceData, client *docker.Client) error {
+	// The ID is the reference created by Create
+	imageName := d.Id()
+	image, err := client.InspectImage(imageName)
+	if err == docker.ErrNoSuchImage {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to inspect image %s: %s", imageName, err)
+	}
+	if id := d.Get("id").(string); id != "" && image.ID != id {
+		log.Printf("[WARN] %s now points to image %s, not removing it", imageName, image.ID)
+		return nil
+	}
+
+	policy := d.Get("delete_policy").(string)
+	if policy != "force" {
+		containers, err := dockerImageContainers(client, image.ID)
+		if err != nil {
+			return err
+		}
+		if len(containers) > 0 {
+			if policy == "skip" {
+				log.Printf("[WARN] Image %s is used by containers %s, leaving it in place", imageName, strings.Join(containers, ", "))
+				return nil
+			}
+			return fmt.Errorf("Image %s is used by containers %s, set delete_policy to \"skip\" or \"force\" to destroy anyway",
+				imageName, strings.Join(containers, ", "))
+		}
+	}
+
+	// Without Force only this reference is removed when the image has
+	// others.
+	err = client.RemoveImageExtended(imageName, docker.RemoveImageOptions{
+		Force:   policy == "force",
+		NoPrune: d.Get("noprune").(bool),
 	})
+	if err != nil && err != docker.ErrNoSuchImage {
+		return fmt.Errorf("Error deleting image %s: %s", imageName, err)
+	}
+	return nil
+}
+
+// dockerImageContainers returns the names of the containers, running or
+// not, created from imageID.
+func dockerImageContainers(client *docker.Client, imageID string) ([]string, error) {
+	containers, err := client.ListContainers(docker.ListContainersOptions{
+		All:     true,
+		Filters: map[string][]string{"ancestor": {imageID}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list containers: %s", err)
+	}
+
+	var names []string
+	for _, c := range containers {
+		// ancestor also matches containers of images built on top of it
+		container, err := client.InspectContainer(c.ID)
+		if _, ok := err.(*docker.NoSuchContainer); ok {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Unable to inspect container %s: %s", c.ID, err)
+		}
+		if container.Image == imageID {
+			names = append(names, strings.TrimPrefix(container.Name, "/"))
+		}
+	}
+	return names, nil
 }
 
 func resourceDockerImageExists(d *schema.ResourceData, meta interface{}) (bool, error) {
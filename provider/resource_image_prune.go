@@ -0,0 +1,254 @@
+package provider
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/terraform/helper/schema"
+
+	dc "github.com/fsouza/go-dockerclient"
+)
+
+// resourceDockerImagePrune removes unused images, and optionally stopped
+// containers and unused volumes, when it is created. Every argument forces
+// a new prune, so changing triggers runs it again.
+func resourceDockerImagePrune() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceDockerImagePruneCreate,
+		Read:   resourceDockerImagePruneRead,
+		Delete: resourceDockerImagePruneDelete,
+
+		Schema: map[string]*schema.Schema{
+			"host": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: true,
+			},
+
+			"machine_name": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: true,
+			},
+
+			"cert_path": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: true,
+			},
+
+			"ca_material": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: true,
+			},
+
+			"cert_material": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: true,
+			},
+
+			"key_material": {
+				Type:      schema.TypeString,
+				Optional:  true,
+				ForceNew:  true,
+				Sensitive: true,
+			},
+
+			// Only remove untagged images. When false every image not
+			// used by a container is removed.
+			"dangling_only": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				ForceNew: true,
+				Default:  true,
+			},
+
+			// Only prune what carries these labels, a "" value only
+			// requires the label to be present.
+			"label_filters": {
+				Type:     schema.TypeMap,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+				Optional: true,
+				ForceNew: true,
+			},
+
+			// Only prune images and containers created before this
+			// duration ago (e.g. "24h") or timestamp.
+			"until": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: true,
+			},
+
+			"prune_containers": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				ForceNew: true,
+			},
+
+			// Volumes are pruned with label_filters only, the daemon does
+			// not support until for them.
+			"prune_volumes": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				ForceNew: true,
+			},
+
+			// The build cache is pruned with until only, and entirely
+			// when dangling_only is false.
+			"prune_build_cache": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				ForceNew: true,
+			},
+
+			// Arbitrary values that run the prune again when they change.
+			"triggers": {
+				Type:     schema.TypeMap,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+				Optional: true,
+				ForceNew: true,
+			},
+
+			"space_reclaimed": {
+				Type:     schema.TypeInt,
+				Computed: true,
+			},
+
+			"build_cache_space_reclaimed": {
+				Type:     schema.TypeInt,
+				Computed: true,
+			},
+
+			"images_deleted": {
+				Type:     schema.TypeList,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+				Computed: true,
+			},
+
+			"containers_deleted": {
+				Type:     schema.TypeList,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+				Computed: true,
+			},
+
+			"volumes_deleted": {
+				Type:     schema.TypeList,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+				Computed: true,
+			},
+		},
+	}
+}
+
+func resourceDockerImagePruneCreate(d *schema.ResourceData, meta interface{}) error {
+	providerConfig := meta.(*ProviderConfig)
+	resolvedConfig, _, err := providerConfig.GetResolvedConfig(d)
+	if err != nil {
+		return err
+	}
+	client, err := resolvedConfig.NewClient()
+	if err != nil {
+		return err
+	}
+
+	var labels []string
+	for k, v := range d.Get("label_filters").(map[string]interface{}) {
+		if v.(string) == "" {
+			labels = append(labels, k)
+		} else {
+			labels = append(labels, k+"="+v.(string))
+		}
+	}
+	until := d.Get("until").(string)
+
+	var spaceReclaimed int64
+
+	// Containers go first, so that the images they held can be pruned
+	containersDeleted := []string{}
+	if d.Get("prune_containers").(bool) {
+		filters := map[string][]string{}
+		if len(labels) > 0 {
+			filters["label"] = labels
+		}
+		if until != "" {
+			filters["until"] = []string{until}
+		}
+		result, err := client.PruneContainers(dc.PruneContainersOptions{Filters: filters})
+		if err != nil {
+			return fmt.Errorf("Unable to prune containers: %s", err)
+		}
+		containersDeleted = append(containersDeleted, result.ContainersDeleted...)
+		spaceReclaimed += result.SpaceReclaimed
+	}
+
+	filters := map[string][]string{
+		"dangling": {strconv.FormatBool(d.Get("dangling_only").(bool))},
+	}
+	if len(labels) > 0 {
+		filters["label"] = labels
+	}
+	if until != "" {
+		filters["until"] = []string{until}
+	}
+	result, err := client.PruneImages(dc.PruneImagesOptions{Filters: filters})
+	if err != nil {
+		return fmt.Errorf("Unable to prune images: %s", err)
+	}
+	imagesDeleted := []string{}
+	for _, image := range result.ImagesDeleted {
+		if image.Deleted != "" {
+			imagesDeleted = append(imagesDeleted, image.Deleted)
+		}
+	}
+	spaceReclaimed += result.SpaceReclaimed
+
+	volumesDeleted := []string{}
+	if d.Get("prune_volumes").(bool) {
+		filters := map[string][]string{}
+		if len(labels) > 0 {
+			filters["label"] = labels
+		}
+		result, err := client.PruneVolumes(dc.PruneVolumesOptions{Filters: filters})
+		if err != nil {
+			return fmt.Errorf("Unable to prune volumes: %s", err)
+		}
+		volumesDeleted = append(volumesDeleted, result.VolumesDeleted...)
+		spaceReclaimed += result.SpaceReclaimed
+	}
+
+	var buildCacheReclaimed int64
+	if d.Get("prune_build_cache").(bool) {
+		buildCacheReclaimed, err = dockerPruneBuildCache(client, !d.Get("dangling_only").(bool), until)
+		if err != nil {
+			return fmt.Errorf("Unable to prune build cache: %s", err)
+		}
+		spaceReclaimed += buildCacheReclaimed
+	}
+
+	log.Printf("[INFO] Pruned %d images, %d containers and %d volumes, reclaiming %d bytes",
+		len(imagesDeleted), len(containersDeleted), len(volumesDeleted), spaceReclaimed)
+
+	d.SetId(strconv.FormatInt(time.Now().UnixNano(), 10))
+	d.Set("space_reclaimed", int(spaceReclaimed))
+	d.Set("build_cache_space_reclaimed", int(buildCacheReclaimed))
+	d.Set("images_deleted", imagesDeleted)
+	d.Set("containers_deleted", containersDeleted)
+	d.Set("volumes_deleted", volumesDeleted)
+	return nil
+}
+
+// The results of the prune are kept as they were, there is nothing on the
+// host to refresh them from.
+func resourceDockerImagePruneRead(d *schema.ResourceData, meta interface{}) error {
+	return nil
+}
+
+func resourceDockerImagePruneDelete(d *schema.ResourceData, meta interface{}) error {
+	d.SetId("")
+	return nil
+}
@@ -0,0 +1,251 @@
+package provider
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/hashicorp/terraform/helper/schema"
+
+	dc "github.com/fsouza/go-dockerclient"
+)
+
+// resourceDockerImageTag adds a repository:tag to an existing image, e.g.
+// to promote app:1.2.3 to app:stable. When the tag is moved to another
+// image outside of Terraform it is tagged again on the next apply.
+func resourceDockerImageTag() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceDockerImageTagCreate,
+		Read:   resourceDockerImageTagRead,
+		Update: resourceDockerImageTagUpdate,
+		Delete: resourceDockerImageTagDelete,
+		Exists: resourceDockerImageTagExists,
+
+		CustomizeDiff: resourceDockerImageTagCustomizeDiff,
+
+		Schema: map[string]*schema.Schema{
+			"host": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: true,
+			},
+
+			"machine_name": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: true,
+			},
+
+			"cert_path": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: false,
+			},
+
+			"ca_material": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: false,
+			},
+
+			"cert_material": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: false,
+			},
+
+			"key_material": {
+				Type:      schema.TypeString,
+				Optional:  true,
+				ForceNew:  false,
+				Sensitive: true,
+			},
+
+			// ID or name of the image to tag.
+			"source_image": {
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: true,
+			},
+
+			"repository": {
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: true,
+			},
+
+			"tag": {
+				Type:     schema.TypeString,
+				Default:  "latest",
+				Optional: true,
+				ForceNew: true,
+			},
+
+			// ID of the image the tag points to. A change of the image
+			// source_image refers to forces the tag to be applied again.
+			"image_id": {
+				Type:     schema.TypeString,
+				Computed: true,
+				ForceNew: true,
+			},
+		},
+	}
+}
+
+func resourceDockerImageTagCreate(d *schema.ResourceData, meta interface{}) error {
+	providerConfig := meta.(*ProviderConfig)
+	resolvedConfig, _, err := providerConfig.GetResolvedConfig(d)
+	if err != nil {
+		return err
+	}
+	client, err := resolvedConfig.NewClient()
+	if err != nil {
+		return err
+	}
+
+	source := d.Get("source_image").(string)
+	image, err := client.InspectImage(source)
+	if err != nil {
+		return fmt.Errorf("Unable to inspect image %s: %s", source, err)
+	}
+
+	repository := d.Get("repository").(string)
+	tag := d.Get("tag").(string)
+	err = client.TagImage(image.ID, dc.TagImageOptions{
+		Repo:  repository,
+		Tag:   tag,
+		Force: true,
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to tag image %s as %s:%s: %s", source, repository, tag, err)
+	}
+
+	d.SetId(repository + ":" + tag)
+	d.Set("image_id", image.ID)
+	return resourceDockerImageTagRead(d, meta)
+}
+
+func resourceDockerImageTagRead(d *schema.ResourceData, meta interface{}) error {
+	providerConfig := meta.(*ProviderConfig)
+	resolvedConfig, _, err := providerConfig.GetResolvedConfig(d)
+	if err != nil {
+		return err
+	}
+	client, err := resolvedConfig.NewClient()
+	if err != nil {
+		return err
+	}
+
+	image, err := client.InspectImage(d.Id())
+	if err == dc.ErrNoSuchImage {
+		d.SetId("")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to inspect image %s: %s", d.Id(), err)
+	}
+
+	if image.ID != d.Get("image_id").(string) {
+		log.Printf("[WARN] Tag %s moved from image %s to %s, it will be applied again", d.Id(), d.Get("image_id").(string), image.ID)
+		d.SetId("")
+	}
+	return nil
+}
+
+func resourceDockerImageTagUpdate(d *schema.ResourceData, meta interface{}) error {
+	return nil
+}
+
+func resourceDockerImageTagDelete(d *schema.ResourceData, meta interface{}) error {
+	providerConfig := meta.(*ProviderConfig)
+	resolvedConfig, _, err := providerConfig.GetResolvedConfig(d)
+	if err != nil {
+		return err
+	}
+	client, err := resolvedConfig.NewClient()
+	if err != nil {
+		return err
+	}
+
+	// Leave the tag alone if it has been moved to another image since
+	image, err := client.InspectImage(d.Id())
+	if err == dc.ErrNoSuchImage {
+		d.SetId("")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to inspect image %s: %s", d.Id(), err)
+	}
+	if image.ID != d.Get("image_id").(string) {
+		log.Printf("[WARN] Tag %s now points to image %s, not removing it", d.Id(), image.ID)
+		d.SetId("")
+		return nil
+	}
+
+	// Without Force only the tag is removed while other references to the
+	// image remain.
+	if err := client.RemoveImage(d.Id()); err != nil && err != dc.ErrNoSuchImage {
+		return fmt.Errorf("Error deleting image tag %s: %s", d.Id(), err)
+	}
+
+	d.SetId("")
+	return nil
+}
+
+func resourceDockerImageTagExists(d *schema.ResourceData, meta interface{}) (bool, error) {
+	providerConfig := meta.(*ProviderConfig)
+	resolvedConfig, deferred, err := providerConfig.GetResolvedConfig(d)
+	if deferred {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	client, err := resolvedConfig.NewClient()
+	if err != nil {
+		return false, err
+	}
+
+	_, err = client.InspectImage(d.Id())
+	switch err {
+	case nil:
+		return true, nil
+	case dc.ErrNoSuchImage:
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// resourceDockerImageTagCustomizeDiff retags when source_image, typically a
+// name rather than an ID, now refers to another image.
+func resourceDockerImageTagCustomizeDiff(d *schema.ResourceDiff, meta interface{}) error {
+	if d.Id() == "" || !d.NewValueKnown("source_image") || d.HasChange("source_image") {
+		return nil
+	}
+
+	providerConfig := meta.(*ProviderConfig)
+	resolvedConfig, deferred, err := providerConfig.GetResolvedConfig(d)
+	if deferred {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	client, err := resolvedConfig.NewClient()
+	if err != nil {
+		return err
+	}
+
+	source := d.Get("source_image").(string)
+	image, err := client.InspectImage(source)
+	if err == dc.ErrNoSuchImage {
+		return d.SetNewComputed("image_id")
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to inspect image %s: %s", source, err)
+	}
+	if image.ID != d.Get("image_id").(string) {
+		return d.SetNew("image_id", image.ID)
+	}
+	return nil
+}
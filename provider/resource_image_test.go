@@ -0,0 +1,409 @@
+package provider
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/hashicorp/terraform/config"
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+// fakeDockerPushDaemon answers the tag and push requests of the Docker API
+// and records them, as "tag <image> <repo>:<tag>" and
+// "push <repo>:<tag> <username>".
+type fakeDockerPushDaemon struct {
+	mu       sync.Mutex
+	requests []string
+	fail     string
+}
+
+func (f *fakeDockerPushDaemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/images/")
+	switch {
+	case r.Method == "POST" && strings.HasSuffix(path, "/tag"):
+		f.requests = append(f.requests, fmt.Sprintf("tag %s %s:%s",
+			strings.TrimSuffix(path, "/tag"), r.URL.Query().Get("repo"), r.URL.Query().Get("tag")))
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == "POST" && strings.HasSuffix(path, "/push"):
+		name := strings.TrimSuffix(path, "/push") + ":" + r.URL.Query().Get("tag")
+		var auth docker.AuthConfiguration
+		if header := r.Header.Get("X-Registry-Auth"); header != "" {
+			data, _ := base64.URLEncoding.DecodeString(header)
+			json.Unmarshal(data, &auth)
+		}
+		f.requests = append(f.requests, fmt.Sprintf("push %s %s", name, auth.Username))
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, "{\"status\":\"The push refers to repository [%s]\"}\n", name)
+		if name == f.fail {
+			fmt.Fprint(w, "{\"errorDetail\":{\"message\":\"denied: requested access to the resource is denied\"},\"error\":\"denied\"}\n")
+			return
+		}
+		fmt.Fprintf(w, "{\"aux\":{\"Tag\":%q,\"Digest\":%q,\"Size\":1234}}\n", r.URL.Query().Get("tag"), fakeDockerDigest(name))
+	default:
+		http.Error(w, "unexpected request "+r.Method+" "+r.URL.Path, http.StatusNotFound)
+	}
+}
+
+func fakeDockerDigest(name string) string {
+	return fmt.Sprintf("sha256:%064x", len(name))
+}
+
+func newFakeDockerPushClient(t *testing.T, daemon *fakeDockerPushDaemon) (*docker.Client, *httptest.Server) {
+	server := httptest.NewServer(daemon)
+	client, err := docker.NewClient(server.URL)
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+	return client, server
+}
+
+func TestPushDockerImageTargets(t *testing.T) {
+	daemon := &fakeDockerPushDaemon{}
+	client, server := newFakeDockerPushClient(t, daemon)
+	defer server.Close()
+
+	d := schema.TestResourceDataRaw(t, resourceDockerImage().Schema, map[string]interface{}{
+		"name": "app",
+		"tag":  "v1",
+		"push": true,
+		"push_targets": []interface{}{
+			map[string]interface{}{
+				"repository": "mirror/app",
+			},
+			map[string]interface{}{
+				"registry":   "registry.example.com:5000",
+				"repository": "team/app",
+				"tag":        "stable",
+				"username":   "robot",
+				"password":   "secret",
+			},
+		},
+	})
+
+	if err := pushDockerImage(d, client, dockerImageRepoName(d), map[string]docker.AuthConfiguration{}, true); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"push app:v1 ",
+		"tag app:v1 mirror/app:latest",
+		"push mirror/app:latest ",
+		"tag app:v1 registry.example.com:5000/team/app:stable",
+		"push registry.example.com:5000/team/app:stable robot",
+	}
+	if !reflect.DeepEqual(daemon.requests, expected) {
+		t.Fatalf("expected requests %q, got %q", expected, daemon.requests)
+	}
+
+	digests := d.Get("push_digests").(map[string]interface{})
+	expectedDigests := map[string]interface{}{
+		"app:v1":            fakeDockerDigest("app:v1"),
+		"mirror/app:latest": fakeDockerDigest("mirror/app:latest"),
+		"registry.example.com:5000/team/app:stable": fakeDockerDigest("registry.example.com:5000/team/app:stable"),
+	}
+	if !reflect.DeepEqual(digests, expectedDigests) {
+		t.Fatalf("expected push_digests %v, got %v", expectedDigests, digests)
+	}
+}
+
+func TestPushDockerImageDropsStaleDigests(t *testing.T) {
+	daemon := &fakeDockerPushDaemon{}
+	client, server := newFakeDockerPushClient(t, daemon)
+	defer server.Close()
+
+	d := schema.TestResourceDataRaw(t, resourceDockerImage().Schema, map[string]interface{}{
+		"name": "app",
+		"tag":  "v1",
+		"push_targets": []interface{}{
+			map[string]interface{}{
+				"repository": "mirror/app",
+			},
+		},
+	})
+	d.Set("push_digests", map[string]interface{}{
+		"app:v1":            "sha256:old",
+		"mirror/app:latest": "sha256:old",
+	})
+
+	if err := pushDockerImage(d, client, dockerImageRepoName(d), map[string]docker.AuthConfiguration{}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	digests := d.Get("push_digests").(map[string]interface{})
+	expected := map[string]interface{}{
+		"mirror/app:latest": fakeDockerDigest("mirror/app:latest"),
+	}
+	if !reflect.DeepEqual(digests, expected) {
+		t.Fatalf("expected push_digests %v, got %v", expected, digests)
+	}
+}
+
+func TestPushDockerImageError(t *testing.T) {
+	daemon := &fakeDockerPushDaemon{fail: "mirror/app:latest"}
+	client, server := newFakeDockerPushClient(t, daemon)
+	defer server.Close()
+
+	d := schema.TestResourceDataRaw(t, resourceDockerImage().Schema, map[string]interface{}{
+		"name": "app",
+		"push_targets": []interface{}{
+			map[string]interface{}{
+				"repository": "mirror/app",
+			},
+		},
+	})
+
+	err := pushDockerImage(d, client, dockerImageRepoName(d), map[string]docker.AuthConfiguration{}, true)
+	if err == nil || !strings.Contains(err.Error(), "requested access to the resource is denied") {
+		t.Fatalf("expected the push error to be reported, got %v", err)
+	}
+}
+
+func TestDockerImageRepoName(t *testing.T) {
+	cases := []struct {
+		registry string
+		expected string
+	}{
+		{"", "app"},
+		{"registry.example.com:5000", "registry.example.com:5000/app"},
+	}
+
+	for _, c := range cases {
+		d := schema.TestResourceDataRaw(t, resourceDockerImage().Schema, map[string]interface{}{
+			"name":     "app",
+			"registry": c.registry,
+		})
+		if name := dockerImageRepoName(d); name != c.expected {
+			t.Errorf("registry %q: expected %q, got %q", c.registry, c.expected, name)
+		}
+	}
+}
+
+func TestImportDockerImageSource(t *testing.T) {
+	var sources []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sources = append(sources, r.URL.Query().Get("fromSrc"))
+		fmt.Fprint(w, "{\"status\":\"sha256:imported\"}\n")
+	}))
+	defer server.Close()
+	client, err := docker.NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "docker-import")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rootfs := writeTestFile(t, dir, "rootfs.tar", "rootfs", 0644)
+
+	cases := []struct {
+		importPath string
+		source     string
+		err        string
+	}{
+		{rootfs, "-", ""},
+		{"https://example.com/rootfs.tar", "https://example.com/rootfs.tar", ""},
+		// Only http(s) URLs are fetched by the daemon
+		{filepath.Join(dir, "missing.tar"), "", "file not found"},
+		{"example.com/rootfs.tar", "", "file not found"},
+	}
+
+	for _, c := range cases {
+		sources = nil
+		d := schema.TestResourceDataRaw(t, resourceDockerImage().Schema, map[string]interface{}{
+			"name":        "app",
+			"import_path": c.importPath,
+		})
+		err := importDockerImage(d, client, "app", "app:latest")
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) || len(sources) != 0 {
+				t.Errorf("%s: expected %q without a request, got %v after %q", c.importPath, c.err, err, sources)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", c.importPath, err)
+			continue
+		}
+		if !reflect.DeepEqual(sources, []string{c.source}) {
+			t.Errorf("%s: expected fromSrc %q, got %q", c.importPath, c.source, sources)
+		}
+	}
+}
+
+func TestBuildDockerImageQuery(t *testing.T) {
+	var query url.Values
+	var registryConfig string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		registryConfig = r.Header.Get("X-Registry-Config")
+		fmt.Fprint(w, "{\"aux\":{\"ID\":\"sha256:built\"}}\n")
+	}))
+	defer server.Close()
+	client, err := docker.NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := buildDockerImage(client, docker.BuildImageOptions{
+		Name:        "app:latest",
+		InputStream: strings.NewReader(""),
+		BuildArgs:   []docker.BuildArg{{Name: "VERSION", Value: "1"}},
+		AuthConfigs: docker.AuthConfigurations{
+			Configs: map[string]docker.AuthConfiguration{"registry.example.com": {Username: "user"}},
+		},
+	}, true, "process", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "sha256:built" {
+		t.Errorf("expected image sha256:built, got %q", id)
+	}
+	expected := map[string]string{
+		"t":         "app:latest",
+		"rm":        "false",
+		"squash":    "1",
+		"isolation": "process",
+		"buildargs": `{"VERSION":"1"}`,
+	}
+	for k, v := range expected {
+		if query.Get(k) != v {
+			t.Errorf("expected %s=%q, got %q", k, v, query.Get(k))
+		}
+	}
+	auth, err := base64.URLEncoding.DecodeString(registryConfig)
+	if err != nil || !strings.Contains(string(auth), `"registry.example.com"`) {
+		t.Errorf("expected the auth of registry.example.com, got %q", registryConfig)
+	}
+}
+
+// A build context that does not exist yet is planned as unknown, so that it
+// can be generated by another resource during the apply.
+func TestResourceDockerImageCustomizeDiffMissingContext(t *testing.T) {
+	dir, err := ioutil.TempDir("", "docker-context")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTestFile(t, dir, "Dockerfile", "FROM scratch\n", 0644)
+
+	cases := []struct {
+		path     string
+		computed bool
+	}{
+		{dir, false},
+		{filepath.Join(dir, "missing"), true},
+	}
+
+	for _, c := range cases {
+		settings := map[string]interface{}{
+			"name":             "app",
+			"build_local_path": c.path,
+		}
+		raw, err := config.NewRawConfig(settings)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		diff, err := resourceDockerImage().Diff(nil, terraform.NewResourceConfig(raw), &ProviderConfig{})
+		if err != nil {
+			t.Fatalf("%s: %s", c.path, err)
+		}
+		attr := diff.Attributes["context_digest"]
+		if attr == nil || attr.NewComputed != c.computed || (!c.computed && attr.New == "") {
+			t.Errorf("%s: expected context_digest to be computed %v, got %#v", c.path, c.computed, attr)
+		}
+	}
+}
+
+// fakeDockerImageDaemon serves an image used by one container and records
+// image removals as "delete <name> force=<force> noprune=<noprune>".
+type fakeDockerImageDaemon struct {
+	imageID string
+	deletes []string
+}
+
+func (f *fakeDockerImageDaemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == "DELETE" && strings.Contains(r.URL.Path, "/images/"):
+		name := r.URL.Path[strings.Index(r.URL.Path, "/images/")+len("/images/"):]
+		f.deletes = append(f.deletes, fmt.Sprintf("delete %s force=%s noprune=%s",
+			name, r.URL.Query().Get("force"), r.URL.Query().Get("noprune")))
+		fmt.Fprint(w, "[]")
+	case strings.Contains(r.URL.Path, "/images/"):
+		fmt.Fprintf(w, "{\"Id\":%q}", f.imageID)
+	case strings.HasSuffix(r.URL.Path, "/containers/json"):
+		fmt.Fprint(w, "[{\"Id\":\"web\"}]")
+	case strings.HasSuffix(r.URL.Path, "/containers/web/json"):
+		fmt.Fprint(w, "{\"Id\":\"web\",\"Name\":\"/web\",\"Image\":\"sha256:app\"}")
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestRemoveDockerImage(t *testing.T) {
+	cases := []struct {
+		name    string
+		imageID string
+		policy  string
+		noprune bool
+		deletes []string
+		err     string
+	}{
+		{"refuse", "sha256:app", "error", false, nil, "is used by containers web"},
+		{"skip", "sha256:app", "skip", false, nil, ""},
+		{"force", "sha256:app", "force", false, []string{"delete app:latest force=1 noprune="}, ""},
+		{"force noprune", "sha256:app", "force", true, []string{"delete app:latest force=1 noprune=1"}, ""},
+		// The reference was moved to another image since it was created
+		{"moved", "sha256:other", "force", false, nil, ""},
+	}
+
+	for _, c := range cases {
+		daemon := &fakeDockerImageDaemon{imageID: c.imageID}
+		server := httptest.NewServer(daemon)
+		client, err := docker.NewClient(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		d := schema.TestResourceDataRaw(t, resourceDockerImage().Schema, map[string]interface{}{
+			"name":          "app",
+			"delete_policy": c.policy,
+			"noprune":       c.noprune,
+		})
+		d.SetId("app:latest")
+		d.Set("id", "sha256:app")
+
+		err = removeDockerImage(d, client)
+		server.Close()
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%s: expected %q, got %v", c.name, c.err, err)
+			}
+		} else if err != nil {
+			t.Errorf("%s: %s", c.name, err)
+		}
+		if !reflect.DeepEqual(daemon.deletes, c.deletes) {
+			t.Errorf("%s: expected %q, got %q", c.name, c.deletes, daemon.deletes)
+		}
+	}
+}
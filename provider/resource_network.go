@@ -224,7 +224,7 @@ func resourceDockerNetworkCreate(d *schema.ResourceData, meta interface{}) error
 	}
 
 	if ipamOptsSet {
-		createOpts.IPAM = ipamOpts
+		createOpts.IPAM = &ipamOpts
 	}
 
 	var retNetwork *dc.Network